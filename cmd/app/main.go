@@ -3,6 +3,9 @@ package main
 import (
 	"todo/internal/app"
 	"todo/internal/config"
+
+	// Timezone preferences must resolve even on images without zoneinfo
+	_ "time/tzdata"
 )
 
 func main() {
@@ -0,0 +1,242 @@
+// Command mock-oidc is an OpenID Connect provider for local development and
+// testing of the single sign-on flow. It logs in whoever asks, as the email
+// in the login_hint or MOCK_OIDC_EMAIL, and accepts any client secret.
+//
+//	MOCK_OIDC_ADDR=:9000 MOCK_OIDC_ISSUER=http://localhost:9000 go run ./cmd/mock-oidc
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+	"todo/internal/oidc"
+	"todo/internal/utils/token"
+)
+
+const keyID = "mock"
+
+type authorization struct {
+	ClientID    string
+	RedirectURI string
+	Nonce       string
+	Challenge   string
+	Email       string
+	Expires     time.Time
+}
+
+type issuer struct {
+	url   string
+	email string
+	key   *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+func getEnv(key string, fallback string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+
+	return fallback
+}
+
+func main() {
+	addr := getEnv("MOCK_OIDC_ADDR", ":9000")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal("failed to generate signing key: ", err)
+	}
+
+	iss := &issuer{
+		url:   getEnv("MOCK_OIDC_ISSUER", "http://localhost"+addr),
+		email: getEnv("MOCK_OIDC_EMAIL", "user@example.com"),
+		key:   key,
+		codes: map[string]authorization{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", iss.discovery)
+	mux.HandleFunc("GET /authorize", iss.authorize)
+	mux.HandleFunc("POST /token", iss.token)
+	mux.HandleFunc("GET /jwks", iss.jwks)
+
+	log.Println("mock oidc issuer", iss.url, "listening on", addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func (iss *issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                iss.url,
+		"authorization_endpoint":                iss.url + "/authorize",
+		"token_endpoint":                        iss.url + "/token",
+		"jwks_uri":                              iss.url + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (iss *issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := iss.key.PublicKey
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize approves every request at once, redirecting back with a code.
+func (iss *issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	redirect_uri, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirect_uri.Scheme == "" || query.Get("client_id") == "" {
+		http.Error(w, "Bad request: client_id and redirect_uri are needed", http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "Bad request: only the code flow with S256 PKCE is supported", http.StatusBadRequest)
+		return
+	}
+
+	email := query.Get("login_hint")
+	if email == "" {
+		email = iss.email
+	}
+
+	code, err := token.Generate(32)
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	iss.mu.Lock()
+	iss.codes[code] = authorization{
+		ClientID:    query.Get("client_id"),
+		RedirectURI: redirect_uri.String(),
+		Nonce:       query.Get("nonce"),
+		Challenge:   query.Get("code_challenge"),
+		Email:       email,
+		Expires:     time.Now().Add(time.Minute),
+	}
+	iss.mu.Unlock()
+
+	callback := redirect_uri.Query()
+	callback.Set("code", code)
+	callback.Set("state", query.Get("state"))
+	redirect_uri.RawQuery = callback.Encode()
+
+	http.Redirect(w, r, redirect_uri.String(), http.StatusFound)
+}
+
+func (iss *issuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	client_id, _, ok := r.BasicAuth()
+	if ok {
+		client_id, _ = url.QueryUnescape(client_id)
+	} else {
+		client_id = r.PostForm.Get("client_id")
+	}
+
+	code := r.PostForm.Get("code")
+
+	iss.mu.Lock()
+	auth, found := iss.codes[code]
+	delete(iss.codes, code)
+	iss.mu.Unlock()
+
+	if !found || time.Now().After(auth.Expires) || auth.ClientID != client_id || auth.RedirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(oidc.Challenge(r.PostForm.Get("code_verifier"))), []byte(auth.Challenge)) != 1 {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+
+	id_token, err := iss.sign(map[string]any{
+		"iss":            iss.url,
+		"sub":            token.Hash(auth.Email)[:24],
+		"aud":            auth.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          auth.Nonce,
+		"email":          auth.Email,
+		"email_verified": true,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	access_token, _ := token.Generate(32)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": access_token,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     id_token,
+	})
+}
+
+func (iss *issuer) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signing_input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signing_input))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, iss.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signing_input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -1,19 +1,29 @@
 package application
 
 import (
+	"context"
 	"database/sql"
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
 	"time"
 	"todo/internal/config"
 	"todo/internal/http/handlers"
+	"todo/internal/http/handlers/account"
 	"todo/internal/http/handlers/auth"
+	"todo/internal/http/handlers/calendar"
+	"todo/internal/http/handlers/dav"
 	"todo/internal/http/handlers/todo"
 	"todo/internal/log"
+	"todo/internal/mail"
 	"todo/internal/middleware"
+	"todo/internal/oidc"
 	"todo/internal/storage/postgres"
 	redis_ "todo/internal/storage/redis"
+	"todo/internal/utils/password"
+	"todo/internal/utils/task"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -61,16 +71,88 @@ func (app *App) Init() {
 		app.Logger.Info("redis connection established")
 	}
 
-	authH := &auth.AuthHandler{DB: app.DB, Cache: app.Cache, Logger: app.Logger}
+	authH := &auth.AuthHandler{
+		DB:         app.DB,
+		Cache:      app.Cache,
+		Logger:     app.Logger,
+		Mailer:     mail.New(app.Cfg, app.Logger),
+		ResetURL:   app.Cfg.ResetURL,
+		VerifyURL:  app.Cfg.VerifyURL,
+		AdminToken: app.Cfg.AdminToken,
+	}
+
+	if app.Cfg.OIDCIssuer != "" {
+		if app.Cfg.OIDCClientID == "" || app.Cfg.OIDCRedirectURL == "" {
+			app.Logger.Error("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are needed with OIDC_ISSUER")
+			os.Exit(1)
+		}
+
+		authH.OIDC = oidc.New(oidc.Config{
+			Issuer:       app.Cfg.OIDCIssuer,
+			ClientID:     app.Cfg.OIDCClientID,
+			ClientSecret: app.Cfg.OIDCClientSecret,
+			RedirectURL:  app.Cfg.OIDCRedirectURL,
+			Scopes:       strings.Fields(app.Cfg.OIDCScopes),
+		})
+	}
+
+	tasksH := &todo.TasksHandler{
+		DB:       app.DB,
+		Cache:    app.Cache,
+		Logger:   app.Logger,
+		StatsTTL: time.Duration(app.Cfg.StatsCacheTTL) * time.Second,
+	}
+
+	calendarH := &calendar.CalendarHandler{DB: app.DB, Cache: app.Cache, Logger: app.Logger}
 
-	tasksH := &todo.TasksHandler{DB: app.DB, Cache: app.Cache, Logger: app.Logger}
+	davH := &dav.DAVHandler{DB: app.DB, Cache: app.Cache, Logger: app.Logger}
+
+	accountH := &account.AccountHandler{
+		DB:             app.DB,
+		Cache:          app.Cache,
+		Logger:         app.Logger,
+		Mailer:         authH.Mailer,
+		DeletionGrace:  time.Duration(app.Cfg.DeletionGrace) * time.Hour,
+		EmailChangeURL: app.Cfg.EmailChangeURL,
+	}
+
+	unverified_policy := app.Cfg.UnverifiedPolicy
+	if unverified_policy == "" {
+		unverified_policy = middleware.PolicyFull
+	}
+
+	if !slices.Contains(middleware.Policies, unverified_policy) {
+		app.Logger.Error("unknown unverified policy", "policy", unverified_policy)
+		os.Exit(1)
+	}
+
+	if app.Cfg.Argon2Memory < 0 || app.Cfg.Argon2Time < 0 || app.Cfg.Argon2Threads < 0 || app.Cfg.Argon2Threads > 255 {
+		app.Logger.Error("invalid argon2 parameters")
+		os.Exit(1)
+	}
+
+	password.Configure(uint32(app.Cfg.Argon2Memory), uint32(app.Cfg.Argon2Time), uint8(app.Cfg.Argon2Threads))
+
+	allowed_origins := []string{}
+
+	for _, origin := range strings.Split(app.Cfg.CSRFAllowedOrigins, ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			allowed_origins = append(allowed_origins, origin)
+		}
+	}
 
 	mux := http.NewServeMux()
-	base := &handlers.BaseHandler{AuthHandler: authH, TasksHandler: tasksH, Mux: mux}
+	base := &handlers.BaseHandler{AuthHandler: authH, TasksHandler: tasksH, CalendarHandler: calendarH, DAVHandler: davH, AccountHandler: accountH, Mux: mux}
 	base.HandleRoutes()
 
 	middleware := middleware.LoggingMiddleWare(
-		middleware.AuthMiddleWare(base.Mux, app.Logger, app.Cache),
+		middleware.CSRFMiddleWare(
+			middleware.IPRateLimitMiddleWare(
+				middleware.AuthMiddleWare(
+					middleware.RateLimitMiddleWare(base.Mux, app.Logger, app.Cache),
+					app.Logger, app.Cache, app.DB, unverified_policy),
+				app.Logger, app.Cache),
+			app.Logger, allowed_origins),
 		app.Logger)
 
 	app.Server.Handler = middleware
@@ -83,6 +165,87 @@ func (app *App) Run() {
 		os.Exit(1)
 	}
 
+	go app.purgeDeletedUsers()
+	go app.rebalancePositions()
+	go app.countLegacyPasswords()
+
 	app.Logger.Info("Application started on port " + app.Cfg.Addr)
 	app.Server.ListenAndServe()
 }
+
+const purgeInterval = 10 * time.Minute
+
+// purgeDeletedUsers removes accounts whose deletion grace period is over.
+func (app *App) purgeDeletedUsers() {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+
+		purged, err := postgres.PurgeDeletedUsers(app.DB, ctx)
+		if err != nil {
+			app.Logger.Error("postgres: purge deleted users error", "err", err)
+		} else if purged > 0 {
+			app.Logger.Info("Deleted accounts purged", "count", purged)
+		}
+
+		cancel()
+	}
+}
+
+const rebalanceInterval = time.Hour
+
+// rebalancePositions shortens the ranks of users whose manual ordering grew
+// long from repeated moves. Users being written to are skipped until the
+// next run.
+func (app *App) rebalancePositions() {
+	ticker := time.NewTicker(rebalanceInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+
+		user_ids, err := postgres.SelectUnbalancedUsers(app.DB, ctx, task_utils.MaxRankLength, 100)
+		if err != nil {
+			app.Logger.Error("postgres: select unbalanced users error", "err", err)
+		}
+
+		for _, user_id := range user_ids {
+			err := postgres.RebalancePositions(app.DB, ctx, user_id)
+			if err != nil {
+				app.Logger.Warn("postgres: rebalance positions error", "user", user_id, "err", err)
+				continue
+			}
+
+			app.Logger.Info("Task positions rebalanced", "user", user_id)
+		}
+
+		cancel()
+	}
+}
+
+const legacyPasswordInterval = time.Hour
+
+// countLegacyPasswords keeps the share of bcrypt hashes up to date as users
+// log in and move to Argon2id, for unknown users to be answered in the same
+// time as known ones.
+func (app *App) countLegacyPasswords() {
+	ticker := time.NewTicker(legacyPasswordInterval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+
+		legacy, total, err := postgres.CountLegacyPasswords(app.DB, ctx)
+		if err != nil {
+			app.Logger.Error("postgres: count legacy passwords error", "err", err)
+		} else {
+			password.SetLegacyShare(legacy, total)
+		}
+
+		cancel()
+
+		<-ticker.C
+	}
+}
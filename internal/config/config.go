@@ -18,6 +18,34 @@ type Config struct {
 	RedisProtocol int
 	LogPath       string
 	LogLevel      string
+	DeletionGrace int
+	StatsCacheTTL int
+	MailDriver    string
+	MailFrom      string
+	MailDir       string
+	SMTPAddr      string
+	SMTPUser      string
+	SMTPPassword  string
+	ResetURL      string
+	VerifyURL     string
+	// UnverifiedPolicy is one of middleware.Policies, "full" when unset
+	UnverifiedPolicy string
+	AdminToken       string
+	EmailChangeURL   string
+	// Argon2 parameters of new password hashes, the defaults when unset
+	Argon2Memory  int
+	Argon2Time    int
+	Argon2Threads int
+	// CSRFAllowedOrigins is a comma separated list of the origins, besides
+	// the API's own, browsers may send unsafe requests from
+	CSRFAllowedOrigins string
+	// OpenID Connect login is enabled by OIDC_ISSUER. OIDCScopes is space
+	// separated, "openid email profile" when unset
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       string
 }
 
 func Load() Config {
@@ -33,12 +61,60 @@ func Load() Config {
 		RedisProtocol: getIntEnv("REDIS_PROTOCOL"),
 		LogPath:       getStringEnv("LOG_PATH"),
 		LogLevel:      getStringEnv("LOG_LEVEL"),
+		DeletionGrace: getIntEnv("ACCOUNT_DELETION_GRACE_HOURS"),
+		StatsCacheTTL: getIntEnv("STATS_CACHE_SECONDS"),
+		MailDriver:    getStringEnv("MAIL_DRIVER"),
+		MailFrom:      getStringEnv("MAIL_FROM"),
+		MailDir:       getStringEnv("MAIL_DIR"),
+		SMTPAddr:      getStringEnv("SMTP_ADDR"),
+		SMTPUser:      getStringEnv("SMTP_USER"),
+		SMTPPassword:  getStringEnv("SMTP_PASSWORD"),
+		ResetURL:      getStringEnv("PASSWORD_RESET_URL"),
+		VerifyURL:     getStringEnv("EMAIL_VERIFY_URL"),
+
+		UnverifiedPolicy: getStringEnv("UNVERIFIED_POLICY"),
+		AdminToken:       getStringEnv("ADMIN_TOKEN"),
+		EmailChangeURL:   getStringEnv("EMAIL_CHANGE_URL"),
+
+		Argon2Memory:  getIntEnv("ARGON2_MEMORY_KIB"),
+		Argon2Time:    getIntEnv("ARGON2_TIME"),
+		Argon2Threads: getIntEnv("ARGON2_THREADS"),
+
+		CSRFAllowedOrigins: getStringEnv("CSRF_ALLOWED_ORIGINS"),
+
+		OIDCIssuer:       getStringEnv("OIDC_ISSUER"),
+		OIDCClientID:     getStringEnv("OIDC_CLIENT_ID"),
+		OIDCClientSecret: getStringEnv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:  getStringEnv("OIDC_REDIRECT_URL"),
+		OIDCScopes:       getStringEnv("OIDC_SCOPES"),
 	}
 }
 
 var notRequiredVars = map[string]string{
-	"REDIS_PASSWORD": "REDIS_PASSWORD",
-	"LOG_PATH":       "LOG_PATH",
+	"REDIS_PASSWORD":               "REDIS_PASSWORD",
+	"LOG_PATH":                     "LOG_PATH",
+	"ACCOUNT_DELETION_GRACE_HOURS": "ACCOUNT_DELETION_GRACE_HOURS",
+	"STATS_CACHE_SECONDS":          "STATS_CACHE_SECONDS",
+	"MAIL_DRIVER":                  "MAIL_DRIVER",
+	"MAIL_FROM":                    "MAIL_FROM",
+	"MAIL_DIR":                     "MAIL_DIR",
+	"SMTP_ADDR":                    "SMTP_ADDR",
+	"SMTP_USER":                    "SMTP_USER",
+	"SMTP_PASSWORD":                "SMTP_PASSWORD",
+	"PASSWORD_RESET_URL":           "PASSWORD_RESET_URL",
+	"EMAIL_VERIFY_URL":             "EMAIL_VERIFY_URL",
+	"UNVERIFIED_POLICY":            "UNVERIFIED_POLICY",
+	"ADMIN_TOKEN":                  "ADMIN_TOKEN",
+	"EMAIL_CHANGE_URL":             "EMAIL_CHANGE_URL",
+	"ARGON2_MEMORY_KIB":            "ARGON2_MEMORY_KIB",
+	"ARGON2_TIME":                  "ARGON2_TIME",
+	"ARGON2_THREADS":               "ARGON2_THREADS",
+	"CSRF_ALLOWED_ORIGINS":         "CSRF_ALLOWED_ORIGINS",
+	"OIDC_ISSUER":                  "OIDC_ISSUER",
+	"OIDC_CLIENT_ID":               "OIDC_CLIENT_ID",
+	"OIDC_CLIENT_SECRET":           "OIDC_CLIENT_SECRET",
+	"OIDC_REDIRECT_URL":            "OIDC_REDIRECT_URL",
+	"OIDC_SCOPES":                  "OIDC_SCOPES",
 }
 
 func getStringEnv(key string) string {
@@ -54,6 +130,11 @@ func getStringEnv(key string) string {
 
 func getIntEnv(key string) int {
 	env_var := os.Getenv(key)
+	_, ok := notRequiredVars[key]
+
+	if env_var == "" && ok {
+		return 0
+	}
 
 	if env_var == "" {
 		log.Fatal("failed to load config, env variable missing:", key)
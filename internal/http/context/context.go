@@ -2,4 +2,12 @@ package ctx
 
 type contextKey string
 
-const UserIDKey contextKey = "userID"
\ No newline at end of file
+const UserIDKey contextKey = "userID"
+
+// TokenIDKey is set instead of a session for requests authenticated by an
+// access token, to a value identifying the token
+const TokenIDKey contextKey = "tokenID"
+
+// LoginAtKey is set for requests authenticated by a session, to when its
+// user logged in, as a unix time
+const LoginAtKey contextKey = "loginAt"
\ No newline at end of file
@@ -0,0 +1,240 @@
+package account
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/mail"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	redis_ "todo/internal/storage/redis"
+	"todo/internal/utils/session"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type AccountHandler struct {
+	DB     *sql.DB
+	Cache  *redis.Client
+	Logger *slog.Logger
+	Mailer mail.Mailer
+	// DeletionGrace delays the purge of a deleted account, zero purges it right away
+	DeletionGrace time.Duration
+	// EmailChangeURL is the page confirmation links point to, with the token appended
+	EmailChangeURL string
+}
+
+func (h *AccountHandler) Export(w http.ResponseWriter, r *http.Request) {
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer db_cancel()
+
+	user, err := postgres.SelectUser(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select user error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	app_passwords, err := postgres.SelectAppPasswords(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select app passwords error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cache_cancel()
+
+	user_sessions, err := redis_.GetUserSessions(h.Cache, cache_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("Redis failed to get user sessions", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Session ids are bearer secrets, so only their metadata is exported
+	sessions := []models.Session{}
+
+	for _, user_session := range user_sessions {
+		sessions = append(sessions, user_session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].IAT < sessions[j].IAT })
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="account-export.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	archive := zip.NewWriter(w)
+
+	err = writeJSONFile(archive, "profile.json", models.Profile{
+		ID:                user.UID,
+		Email:             user.Email,
+		Email_verified_at: user.Email_verified_at,
+		Two_factor:        user.Two_factor,
+		Timezone:          user.Timezone,
+		Created_at:        user.Created_at,
+		Updated_at:        user.Updated_at,
+	})
+	if err == nil {
+		err = writeJSONFile(archive, "sessions.json", sessions)
+	}
+	if err == nil {
+		err = writeJSONFile(archive, "app_passwords.json", app_passwords)
+	}
+	if err == nil {
+		err = writeTasksFile(archive, h.DB, db_ctx, user_id)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+
+	if err != nil {
+		// The archive is already being streamed, so the error can only be logged
+		h.Logger.Error("account export error", "user", user_id, "err", err)
+		return
+	}
+
+	h.Logger.Info("Account data exported", "user", user_id)
+}
+
+func (h *AccountHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var confirmation models.DeleteAccount
+
+	err := json.NewDecoder(r.Body).Decode(&confirmation)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	user, err := postgres.SelectUser(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select user error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = session.ConfirmIdentity(r, user.Password, confirmation.Password)
+	if err != nil {
+		h.Logger.Warn("Identity not confirmed for account deletion", "user", user.Email, "err", err)
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	delete_after := time.Now().Add(h.DeletionGrace)
+
+	if h.DeletionGrace > 0 {
+		err = postgres.ScheduleUserDeletion(h.DB, db_ctx, user_id, delete_after)
+	} else {
+		err = postgres.DeleteUser(h.DB, db_ctx, user_id)
+	}
+
+	if err != nil {
+		h.Logger.Error("postgres: delete user error", "user", user.Email, "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cache_cancel()
+
+	revoked, err := redis_.DeleteUserSessions(h.Cache, cache_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("Redis failed to revoke user sessions", "user", user.Email, "err", err)
+	}
+
+	session.ClearSessionCookie(w)
+
+	if h.DeletionGrace > 0 {
+		h.Logger.Info("Account deletion scheduled", "user", user.Email, "delete_after", delete_after, "sessions", revoked)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"delete_after": delete_after.UTC().Format(time.RFC3339)})
+		return
+	}
+
+	h.Logger.Info("Account deleted", "user", user.Email, "sessions", revoked)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSONFile(archive *zip.Writer, name string, v any) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(v)
+}
+
+// writeTasksFile streams the tasks into the archive as a JSON array instead
+// of loading them all first.
+func writeTasksFile(archive *zip.Writer, DB *sql.DB, ctx context.Context, user_id int) error {
+	file, err := archive.Create("tasks.json")
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write([]byte("[")); err != nil {
+		return err
+	}
+
+	first := true
+
+	err = postgres.StreamUserTasks(DB, ctx, user_id, func(task models.DBtask) error {
+		if !first {
+			if _, err := file.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		first = false
+
+		val, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+
+		_, err = file.Write(append([]byte("\n  "), val...))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write([]byte("\n]\n"))
+	return err
+}
@@ -0,0 +1,263 @@
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/mail"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	redis_ "todo/internal/storage/redis"
+	"todo/internal/utils/session"
+	"todo/internal/utils/token"
+	"todo/internal/utils/validators"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const emailChangeTTL = time.Hour
+
+// ChangePassword sets a new password given the current one, and logs out
+// every other session.
+func (h *AccountHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var change models.ChangePassword
+
+	err := json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	user, err := postgres.SelectUser(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select user error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = session.ConfirmIdentity(r, user.Password, change.Current_password)
+	if err != nil {
+		h.Logger.Warn("Identity not confirmed for user", "user", user.Email, "err", err)
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	err = validators.ValidatePassword(change.New_password)
+	if err != nil {
+		h.Logger.Error("Password format error", "err", err)
+		http.Error(w, "Invalid password format", http.StatusBadRequest)
+		return
+	}
+
+	err = postgres.SetPassword(h.DB, db_ctx, user_id, change.New_password)
+	if err != nil {
+		h.Logger.Error("postgres: set password error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The session making the change stays logged in, unless the request came
+	// with an access token
+	var keep []string
+
+	if session_cookie, err := r.Cookie("session_id"); err == nil && r.Header.Get("Authorization") == "" {
+		keep = append(keep, session_cookie.Value)
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cache_cancel()
+
+	revoked, err := redis_.DeleteUserSessions(h.Cache, cache_ctx, user_id, keep...)
+	if err != nil {
+		h.Logger.Error("Redis failed to revoke user sessions", "user", user.Email, "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	mail.SendBackground(h.Mailer, h.Logger, mail.Message{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body: "The password of your account was just changed, and your other sessions were logged out.\n\n" +
+			"If it wasn't you, reset your password right away.\n",
+	})
+
+	h.Logger.Info("Password was changed", "user", user.Email, "sessions revoked", revoked)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int{"revoked": revoked})
+}
+
+// ChangeEmail mails a confirmation link to the new address, and a notice to
+// the current one. The email only changes once the link is followed.
+func (h *AccountHandler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var change models.ChangeEmail
+
+	err := json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	change.Email = strings.TrimSpace(change.Email)
+
+	err = validators.ValidateEmail(change.Email)
+	if err != nil {
+		h.Logger.Error("Email format error", "email", change.Email, "err", err)
+		http.Error(w, "Invalid email format", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	user, err := postgres.SelectUser(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select user error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = session.ConfirmIdentity(r, user.Password, change.Password)
+	if err != nil {
+		h.Logger.Warn("Identity not confirmed for user", "user", user.Email, "err", err)
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if change.Email == user.Email {
+		http.Error(w, "Bad request: email is already the current one", http.StatusBadRequest)
+		return
+	}
+
+	// Checked again when confirming, this only saves a pointless mail
+	if postgres.UserExistsByEmail(h.DB, db_ctx, change.Email) {
+		h.Logger.Warn("User with provided email exists", "user", change.Email)
+		http.Error(w, "User with provided email exists", http.StatusConflict)
+		return
+	}
+
+	change_token, err := token.Generate(32)
+	if err != nil {
+		h.Logger.Error("email change token generation error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cache_cancel()
+
+	err = redis_.StoreEmailChange(h.Cache, cache_ctx, token.Hash(change_token), models.EmailChange{UID: user_id, Email: change.Email}, emailChangeTTL)
+	if err != nil {
+		h.Logger.Error("redis: store email change error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	mail.SendBackground(h.Mailer, h.Logger, mail.Message{
+		To:      change.Email,
+		Subject: "Confirm your new email address",
+		Body: "Use this to make this the email address of your account within " + emailChangeTTL.String() + ":\n\n" +
+			mail.Link(h.EmailChangeURL, change_token) + "\n\n" +
+			"If you didn't ask for it, you can ignore this mail.\n",
+	})
+
+	mail.SendBackground(h.Mailer, h.Logger, mail.Message{
+		To:      user.Email,
+		Subject: "Your email address is being changed",
+		Body: "A change of the email address of your account to " + change.Email + " was requested.\n" +
+			"It takes effect once confirmed from the new address.\n\n" +
+			"If it wasn't you, change your password right away.\n",
+	})
+
+	h.Logger.Info("Email change requested", "user", user.Email, "email", change.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ConfirmEmail applies a change of email with the token mailed to the new
+// address.
+func (h *AccountHandler) ConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	defer r.Body.Close()
+
+	var confirm models.VerifyEmail
+
+	err := json.NewDecoder(r.Body).Decode(&confirm)
+	if err != nil || confirm.Token == "" {
+		h.Logger.Error("Confirm email error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer cache_cancel()
+
+	change, err := redis_.GetDeleteEmailChange(h.Cache, cache_ctx, token.Hash(confirm.Token))
+	if err == redis.Nil {
+		h.Logger.Warn("Invalid or expired email change token")
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("redis: get email change error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	err = postgres.ChangeEmail(h.DB, db_ctx, change.UID, change.Email)
+	if err == postgres.ErrEmailTaken {
+		h.Logger.Warn("User with provided email exists", "user", change.Email)
+		http.Error(w, "User with provided email exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("postgres: change email error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = redis_.MarkSessionsVerified(h.Cache, cache_ctx, change.UID)
+	if err != nil {
+		h.Logger.Error("redis: mark sessions verified error", "err", err)
+	}
+
+	h.Logger.Info("Email was changed", "user", change.UID, "email", change.Email)
+	w.WriteHeader(http.StatusNoContent)
+}
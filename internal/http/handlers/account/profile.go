@@ -0,0 +1,92 @@
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/validators"
+)
+
+func (h *AccountHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	user, err := postgres.SelectUser(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select user error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.Profile{
+		ID:                user.UID,
+		Email:             user.Email,
+		Email_verified_at: user.Email_verified_at,
+		Two_factor:        user.Two_factor,
+		Timezone:          user.Timezone,
+		Created_at:        user.Created_at,
+		Updated_at:        user.Updated_at,
+	})
+}
+
+// PatchProfile updates the account preferences. The timezone is used to read
+// due dates without an offset and to render dates when no tz param is given.
+func (h *AccountHandler) PatchProfile(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var update_profile models.UpdateProfile
+
+	err := json.NewDecoder(r.Body).Decode(&update_profile)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	if update_profile.Timezone != nil {
+		loc, err := validators.ValidateTimezone(*update_profile.Timezone)
+		if err != nil {
+			h.Logger.Error("validate: timezone validation error", "timezone", *update_profile.Timezone, "err", err)
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		err = postgres.SetUserTimezone(h.DB, db_ctx, user_id, loc.String())
+		if err != nil {
+			h.Logger.Error("postgres: set user timezone error", "err", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.Logger.Info("Profile was updated", "user", user_id)
+	w.WriteHeader(http.StatusOK)
+}
@@ -7,7 +7,9 @@ import (
 	"log/slog"
 	"net/http"
 	"time"
+	"todo/internal/mail"
 	"todo/internal/models"
+	"todo/internal/oidc"
 	"todo/internal/storage/postgres"
 	redis_ "todo/internal/storage/redis"
 	"todo/internal/utils/password"
@@ -21,6 +23,15 @@ type AuthHandler struct {
 	DB     *sql.DB
 	Cache  *redis.Client
 	Logger *slog.Logger
+	Mailer mail.Mailer
+	// ResetURL and VerifyURL are the pages mailed links point to, with the
+	// token appended
+	ResetURL  string
+	VerifyURL string
+	// AdminToken opens the admin routes, which are disabled when empty
+	AdminToken string
+	// OIDC is the single sign-on provider, nil when not configured
+	OIDC *oidc.Provider
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -65,6 +76,15 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user_id, err := postgres.GetUserID(h.DB, db_ctx, user.Email)
+	if err == nil {
+		err = h.sendVerification(r.Context(), user_id, user.Email)
+	}
+	if err != nil {
+		// The account exists by now, the link can be sent again with a resend
+		h.Logger.Error("Verification mail error", "user", user.Email, "err", err)
+	}
+
 	h.Logger.Info("User created: ", "user", user.Email)
 	w.WriteHeader(http.StatusCreated)
 }
@@ -91,26 +111,39 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	throttle_keys := loginThrottleKeys(user.Email, session.GetIP(r))
+
+	if h.lockedOut(w, r, throttle_keys) {
+		return
+	}
+
 	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second * 3)
 	defer db_cancel()
 
-	if !postgres.UserExistsByEmail(h.DB, db_ctx, user.Email) {
+	hashed_password, err := postgres.GetPassword(h.DB, db_ctx, user.Email)
+	if err == sql.ErrNoRows {
+		// Unknown users get the same answer in the same time
+		password.CompareDummy(user.Email, []byte(user.Password))
+
 		h.Logger.Warn("User does not exist", "user", user.Email)
-		time.Sleep(time.Second)
-		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		h.loginFailed(w, r, throttle_keys)
 		return
 	}
-
-	hashed_password, err := postgres.GetPassword(h.DB, db_ctx, user.Email)
 	if err != nil {
 		h.Logger.Error("Get password error", "err", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
 	}
 
+	// Users without a password only sign in with single sign-on, and are
+	// answered in the same time as the others
+	if hashed_password == "" {
+		password.CompareDummy(user.Email, []byte(user.Password))
+	}
+
 	if !password.IsCorrectPassword([]byte(hashed_password), []byte(user.Password)) {
 		h.Logger.Warn("Invalid password for user", "user", user.Email)
-		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		h.loginFailed(w, r, throttle_keys)
 		return
 	}
 
@@ -121,6 +154,57 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if password.NeedsRehash([]byte(hashed_password)) {
+		// The password is only known at login, to move it to the current scheme
+		err = postgres.SetPassword(h.DB, db_ctx, user_id, user.Password)
+		if err != nil {
+			h.Logger.Warn("Password rehash error", "user", user.Email, "err", err)
+		} else {
+			h.Logger.Info("Password rehashed", "user", user.Email)
+		}
+	}
+
+	secret, err := postgres.GetTOTPSecret(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("Get totp secret error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// With two-factor on, the failures are only cleared once the code is
+	// right, or a known password would give endless tries at the code
+	if secret != "" {
+		h.challenge(w, r, user_id, user.Email)
+		return
+	}
+
+	h.loginSucceeded(r, throttle_keys)
+	h.startSession(w, r, user_id, user.Email)
+}
+
+// startSession logs the user in once every factor was checked.
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, user_id int, email string) {
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	restored, err := postgres.CancelUserDeletion(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("Cancel user deletion error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if restored {
+		h.Logger.Info("Scheduled account deletion cancelled by login", "user", email)
+	}
+
+	verified, err := postgres.IsEmailVerified(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("Get email verification error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
 	session_uuid := session.MustGenerateUUID()
 
 	ip := session.GetIP(r)
@@ -130,16 +214,16 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second)
 	defer cache_cancel()
 
-	err = redis_.StoreSession(h.Cache, cache_ctx, session_uuid, user_id, ip, ua)
+	err = redis_.StoreSession(h.Cache, cache_ctx, session_uuid, user_id, ip, ua, verified)
 	if err != nil {
-		h.Logger.Error("Failed to save refresh token", "user", user.Email, "err", err)
+		h.Logger.Error("Failed to save refresh token", "user", email, "err", err)
 		http.Error(w, "Login failed", http.StatusInternalServerError)
 		return
 	}
 
 	session.SetSessionCookie(w, session_uuid)
 
-	h.Logger.Info("Logged in", "user", user.Email)
+	h.Logger.Info("Logged in", "user", email)
 	w.WriteHeader(http.StatusOK)
 }
 
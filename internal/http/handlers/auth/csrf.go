@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"todo/internal/models"
+	redis_ "todo/internal/storage/redis"
+	"todo/internal/utils/token"
+)
+
+// GetCSRFToken returns the CSRF token of the session, for SPAs to send in the
+// X-CSRF-Token header. Sessions from before tokens existed are given one.
+func (h *AuthHandler) GetCSRFToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Vary", "Cookie")
+
+	session_uuid := currentSession(r)
+	if session_uuid == "" {
+		h.Logger.Warn("CSRF token requested without a session")
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cache_cancel()
+
+	session_s, err := redis_.GetSession(h.Cache, cache_ctx, session_uuid)
+	if err != nil {
+		h.Logger.Warn("Session not found", "err", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if session_s.CSRF == "" {
+		session_s.CSRF, err = token.Generate(32)
+		if err != nil {
+			h.Logger.Error("CSRF token generation error", "err", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = redis_.SetSessionCSRF(h.Cache, cache_ctx, session_uuid, session_s)
+		if err != nil {
+			h.Logger.Error("Redis failed to store CSRF token", "err", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.CSRFToken{Token: session_s.CSRF})
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	redis_ "todo/internal/storage/redis"
+	"todo/internal/utils/token"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// How long a login may take at the provider
+const oidcLoginTTL = 10 * time.Minute
+
+// The state is also kept in a cookie, so that a callback only completes the
+// login in the browser that started it.
+const oidcStateCookie = "oidc_state"
+
+func setOIDCStateCookie(w http.ResponseWriter, state string, max_age int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/oidc/",
+		MaxAge:   max_age,
+		Secure:   true,
+		HttpOnly: true,
+		// Sent on the redirect back from the provider, a top-level navigation
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// OIDCLogin starts a login at the OpenID Connect provider. A login_hint
+// query parameter is passed on to it.
+func (h *AuthHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if h.OIDC == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	var secrets [3]string
+
+	for i := range secrets {
+		secret, err := token.Generate(32)
+		if err != nil {
+			h.Logger.Error("oidc secret generation error", "err", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		secrets[i] = secret
+	}
+
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	auth_ctx, auth_cancel := context.WithTimeout(r.Context(), time.Second*10)
+	defer auth_cancel()
+
+	auth_url, err := h.OIDC.AuthCodeURL(auth_ctx, state, nonce, verifier, r.URL.Query().Get("login_hint"))
+	if err != nil {
+		h.Logger.Error("oidc: discovery error", "issuer", h.OIDC.Issuer(), "err", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cache_cancel()
+
+	err = redis_.StoreOIDCLogin(h.Cache, cache_ctx, token.Hash(state), models.OIDCLogin{Nonce: nonce, Verifier: verifier}, oidcLoginTTL)
+	if err != nil {
+		h.Logger.Error("redis: store oidc login error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	setOIDCStateCookie(w, state, int(oidcLoginTTL.Seconds()))
+
+	http.Redirect(w, r, auth_url, http.StatusFound)
+}
+
+// OIDCCallback completes a login the provider redirected back from, with
+// our normal session, or a second factor challenge when two-factor is on.
+func (h *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if h.OIDC == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+
+	state := query.Get("state")
+
+	state_cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(state_cookie.Value), []byte(state)) != 1 {
+		h.Logger.Warn("oidc: state mismatch", "err", err)
+		http.Error(w, "Bad request: login not started in this browser", http.StatusBadRequest)
+		return
+	}
+
+	setOIDCStateCookie(w, "", -1)
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cache_cancel()
+
+	// The login is used up whatever the outcome, a state is good for one try
+	login, err := redis_.GetDeleteOIDCLogin(h.Cache, cache_ctx, token.Hash(state))
+	if err == redis.Nil {
+		h.Logger.Warn("oidc: login expired or already used")
+		http.Error(w, "Bad request: login expired", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("redis: get oidc login error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if provider_err := query.Get("error"); provider_err != "" {
+		h.Logger.Warn("oidc: provider refused login", "error", provider_err, "description", query.Get("error_description"))
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		h.Logger.Warn("oidc: code missing")
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	oidc_ctx, oidc_cancel := context.WithTimeout(r.Context(), time.Second*10)
+	defer oidc_cancel()
+
+	id_token, err := h.OIDC.Exchange(oidc_ctx, code, login.Verifier)
+	if err != nil {
+		h.Logger.Error("oidc: code exchange error", "err", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := h.OIDC.Verify(oidc_ctx, id_token, login.Nonce)
+	if err != nil {
+		h.Logger.Error("oidc: id token rejected", "err", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	user_id, created, err := postgres.LinkIdentity(h.DB, db_ctx, h.OIDC.Issuer(), claims.Subject, claims.Email, bool(claims.EmailVerified))
+	if errors.Is(err, postgres.ErrEmailNotVerified) {
+		h.Logger.Warn("oidc: email not verified by provider", "subject", claims.Subject, "email", claims.Email)
+		http.Error(w, "Email not verified by the identity provider", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, postgres.ErrUnverifiedAccount) {
+		h.Logger.Warn("oidc: existing account not verified", "subject", claims.Subject, "email", claims.Email)
+		http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("postgres: link identity error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if created {
+		h.Logger.Info("User provisioned by identity provider", "user", claims.Email)
+	}
+
+	secret, err := postgres.GetTOTPSecret(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("Get totp secret error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if secret != "" {
+		h.challenge(w, r, user_id, claims.Email)
+		return
+	}
+
+	h.startSession(w, r, user_id, claims.Email)
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"todo/internal/mail"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	redis_ "todo/internal/storage/redis"
+	"todo/internal/utils/token"
+	"todo/internal/utils/validators"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const resetTokenTTL = 30 * time.Minute
+
+// ForgotPassword mails a reset link to the account of the email, if there is
+// one. The response is the same either way, and the mail is sent in the
+// background so that timing doesn't tell accounts apart either.
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	defer r.Body.Close()
+
+	var forgot models.ForgotPassword
+
+	err := json.NewDecoder(r.Body).Decode(&forgot)
+	if err != nil {
+		h.Logger.Error("Forgot password error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = validators.ValidateEmail(forgot.Email)
+	if err != nil {
+		h.Logger.Error("Email format error", "email", forgot.Email, "err", err)
+		http.Error(w, "Invalid email format", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	user_id, err := postgres.GetUserID(h.DB, db_ctx, forgot.Email)
+	if err != nil {
+		h.Logger.Warn("Password reset requested for unknown user", "user", forgot.Email)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	reset_token, err := token.Generate(32)
+	if err != nil {
+		h.Logger.Error("reset token generation error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cache_cancel()
+
+	err = redis_.StoreResetToken(h.Cache, cache_ctx, token.Hash(reset_token), user_id, resetTokenTTL)
+	if err != nil {
+		h.Logger.Error("redis: store reset token error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	mail.SendBackground(h.Mailer, h.Logger, mail.Message{
+		To:      forgot.Email,
+		Subject: "Reset your password",
+		Body: "Someone asked to reset the password of your account.\n\n" +
+			"Use this to choose a new one within " + resetTokenTTL.String() + ":\n\n" +
+			mail.Link(h.ResetURL, reset_token) + "\n\n" +
+			"If it wasn't you, you can ignore this mail.\n",
+	})
+
+	h.Logger.Info("Password reset requested", "user", forgot.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password with a reset token, which is then used
+// up, and logs out every session of the user.
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	defer r.Body.Close()
+
+	var reset models.ResetPassword
+
+	err := json.NewDecoder(r.Body).Decode(&reset)
+	if err != nil || reset.Token == "" {
+		h.Logger.Error("Reset password error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = validators.ValidatePassword(reset.Password)
+	if err != nil {
+		h.Logger.Error("Password format error", "err", err)
+		http.Error(w, "Invalid password format", http.StatusBadRequest)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer cache_cancel()
+
+	user_id, err := redis_.GetDeleteResetToken(h.Cache, cache_ctx, token.Hash(reset.Token))
+	if err == redis.Nil {
+		h.Logger.Warn("Invalid or expired reset token")
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("redis: get reset token error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	err = postgres.SetPassword(h.DB, db_ctx, user_id, reset.Password)
+	if err != nil {
+		h.Logger.Error("postgres: set password error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	revoked, err := redis_.DeleteUserSessions(h.Cache, cache_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("redis: delete user sessions error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("Password was reset", "user", user_id, "sessions revoked", revoked)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/models"
+	redis_ "todo/internal/storage/redis"
+	"todo/internal/utils/token"
+)
+
+// sessionID is the public id of a session, which can't be turned back into
+// the session id itself.
+func sessionID(session_uuid string) string {
+	return token.Hash(session_uuid)[:16]
+}
+
+// currentSession is the session id of the request, empty when it was
+// authenticated another way.
+func currentSession(r *http.Request) string {
+	session_cookie, err := r.Cookie("session_id")
+	if err != nil || r.Header.Get("Authorization") != "" {
+		return ""
+	}
+
+	return session_cookie.Value
+}
+
+func (h *AuthHandler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer cache_cancel()
+
+	user_sessions, err := redis_.GetUserSessions(h.Cache, cache_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("Redis failed to get user sessions", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	current := currentSession(r)
+
+	sessions := []models.SessionInfo{}
+
+	for session_uuid, user_session := range user_sessions {
+		last_seen := user_session.Last_seen
+		if last_seen == 0 {
+			last_seen = user_session.IAT
+		}
+
+		sessions = append(sessions, models.SessionInfo{
+			ID:           sessionID(session_uuid),
+			Device:       user_session.UA,
+			IP:           user_session.IP,
+			Created_at:   time.Unix(user_session.IAT, 0).UTC().Format(time.RFC3339),
+			Last_seen_at: time.Unix(last_seen, 0).UTC().Format(time.RFC3339),
+			Expires_at:   time.Unix(user_session.EXP, 0).UTC().Format(time.RFC3339),
+			Current:      session_uuid == current,
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Last_seen_at > sessions[j].Last_seen_at })
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// DeleteSession revokes one session of the user by its public id. Revoking
+// the current one works as a logout.
+func (h *AuthHandler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer cache_cancel()
+
+	user_sessions, err := redis_.GetUserSessions(h.Cache, cache_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("Redis failed to get user sessions", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	for session_uuid := range user_sessions {
+		if sessionID(session_uuid) != r.PathValue("id") {
+			continue
+		}
+
+		err = redis_.DeleteSession(h.Cache, cache_ctx, user_id, session_uuid)
+		if err != nil {
+			h.Logger.Error("Redis failed to delete session", "err", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		h.Logger.Info("Session was revoked", "user", user_id, "id", r.PathValue("id"))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	h.Logger.Warn("redis: session was not found", "id", r.PathValue("id"))
+	http.Error(w, "Not found", http.StatusNotFound)
+}
+
+// DeleteSessions logs out everywhere. With others=true the current session
+// is kept.
+func (h *AuthHandler) DeleteSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var keep []string
+
+	switch r.URL.Query().Get("others") {
+	case "", "false":
+	case "true":
+		if current := currentSession(r); current != "" {
+			keep = append(keep, current)
+		}
+	default:
+		http.Error(w, "Bad request: others param not a bool value", http.StatusBadRequest)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer cache_cancel()
+
+	revoked, err := redis_.DeleteUserSessions(h.Cache, cache_ctx, user_id, keep...)
+	if err != nil {
+		h.Logger.Error("Redis failed to delete user sessions", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("Sessions were revoked", "user", user_id, "count", revoked)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int{"revoked": revoked})
+}
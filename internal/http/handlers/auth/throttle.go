@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"todo/internal/models"
+	redis_ "todo/internal/storage/redis"
+	"todo/internal/utils/token"
+)
+
+// Failures are counted per account and per IP. The IP allows more, as a
+// whole office may share one, but it also catches guessing across accounts.
+const (
+	accountFreeFailures = 5
+	accountWindow       = 15 * time.Minute
+	accountMaxLock      = 15 * time.Minute
+	ipFreeFailures      = 20
+	ipWindow            = time.Hour
+	ipMaxLock           = time.Hour
+)
+
+type throttleKeys struct {
+	Account string
+	IP      string
+}
+
+func loginThrottleKeys(email string, ip string) throttleKeys {
+	return throttleKeys{
+		Account: "account:" + strings.ToLower(strings.TrimSpace(email)),
+		IP:      "ip:" + ip,
+	}
+}
+
+// lockedOut refuses the login while the account or the IP is locked.
+func (h *AuthHandler) lockedOut(w http.ResponseWriter, r *http.Request, keys throttleKeys) bool {
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cache_cancel()
+
+	lockout, err := redis_.LoginLockout(h.Cache, cache_ctx, keys.Account, keys.IP)
+	if err != nil {
+		h.Logger.Error("redis: login lockout error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return true
+	}
+
+	if lockout <= 0 {
+		return false
+	}
+
+	h.Logger.Warn("Login locked out", "account", keys.Account, "ip", keys.IP, "for", lockout)
+	tooManyAttempts(w, lockout)
+	return true
+}
+
+func (h *AuthHandler) loginFailed(w http.ResponseWriter, r *http.Request, keys throttleKeys) {
+	if lock := h.recordLoginFailure(r, keys); lock > 0 {
+		tooManyAttempts(w, lock)
+		return
+	}
+
+	http.Error(w, "Invalid password", http.StatusUnauthorized)
+}
+
+// recordLoginFailure counts a wrong password or second factor against the
+// account and the IP, and returns the lockout it led to, if any.
+func (h *AuthHandler) recordLoginFailure(r *http.Request, keys throttleKeys) time.Duration {
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cache_cancel()
+
+	account_lock, err := redis_.RecordLoginFailure(h.Cache, cache_ctx, keys.Account, accountWindow, accountFreeFailures, accountMaxLock)
+	if err != nil {
+		h.Logger.Error("redis: record login failure error", "err", err)
+	}
+
+	ip_lock, err := redis_.RecordLoginFailure(h.Cache, cache_ctx, keys.IP, ipWindow, ipFreeFailures, ipMaxLock)
+	if err != nil {
+		h.Logger.Error("redis: record login failure error", "err", err)
+	}
+
+	lock := max(account_lock, ip_lock)
+	if lock > 0 {
+		h.Logger.Warn("Login locked after failures", "account", keys.Account, "ip", keys.IP, "for", lock)
+	}
+
+	return lock
+}
+
+// loginSucceeded clears the failures of the account once every factor was
+// checked. Those of the IP are kept, or guessing could go on by logging into
+// an own account in between.
+func (h *AuthHandler) loginSucceeded(r *http.Request, keys throttleKeys) {
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cache_cancel()
+
+	if _, err := redis_.ClearLoginFailures(h.Cache, cache_ctx, keys.Account); err != nil {
+		h.Logger.Error("redis: clear login failures error", "err", err)
+	}
+}
+
+func tooManyAttempts(w http.ResponseWriter, lockout time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockout.Seconds()))))
+	http.Error(w, "Too many login attempts", http.StatusTooManyRequests)
+}
+
+// UnlockLogin lifts the lockout and clears the failures of an account, an
+// IP, or both. It is authenticated by the ADMIN_TOKEN in an X-Admin-Token
+// header, and is disabled when none is configured.
+func (h *AuthHandler) UnlockLogin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	defer r.Body.Close()
+
+	if h.AdminToken == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	given := token.Hash(r.Header.Get("X-Admin-Token"))
+
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token.Hash(h.AdminToken))) != 1 {
+		h.Logger.Warn("Invalid admin token", "ip", r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var unlock models.UnlockLogin
+
+	err := json.NewDecoder(r.Body).Decode(&unlock)
+	if err != nil || (unlock.Email == "" && unlock.IP == "") {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request: an email or an ip is needed", http.StatusBadRequest)
+		return
+	}
+
+	var keys []string
+
+	if unlock.Email != "" {
+		keys = append(keys, loginThrottleKeys(unlock.Email, "").Account)
+	}
+
+	if unlock.IP != "" {
+		keys = append(keys, loginThrottleKeys("", strings.TrimSpace(unlock.IP)).IP)
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cache_cancel()
+
+	cleared, err := redis_.ClearLoginFailures(h.Cache, cache_ctx, keys...)
+	if err != nil {
+		h.Logger.Error("redis: clear login failures error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("Login unlocked by admin", "email", unlock.Email, "ip", unlock.IP)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]bool{"unlocked": cleared > 0})
+}
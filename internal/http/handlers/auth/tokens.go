@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/token"
+	"todo/internal/utils/validators"
+)
+
+func (h *AuthHandler) CreateAccessToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var new_token models.NewAccessToken
+
+	err := json.NewDecoder(r.Body).Decode(&new_token)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	new_token.Name = strings.TrimSpace(new_token.Name)
+	slices.Sort(new_token.Scopes)
+	new_token.Scopes = slices.Compact(new_token.Scopes)
+
+	err = validators.ValidateAccessToken(new_token)
+	if err != nil {
+		h.Logger.Error("validate: access token validation error", "err", err)
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var expires_at *time.Time
+
+	if new_token.Expires_in_days != nil {
+		expiry := time.Now().AddDate(0, 0, *new_token.Expires_in_days)
+		expires_at = &expiry
+	}
+
+	secret, err := token.Generate(32)
+	if err != nil {
+		h.Logger.Error("access token generation error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	secret = token.AccessTokenPrefix + secret
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	access_token, err := postgres.CreateAccessToken(h.DB, db_ctx, user_id, new_token.Name, new_token.Scopes, expires_at, token.Hash(secret))
+	if err != nil {
+		h.Logger.Error("postgres: create access token error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The plain token is only ever shown in this response
+	access_token.Token = secret
+
+	h.Logger.Info("Access token was created", "user", user_id, "id", access_token.ID, "scopes", access_token.Scopes)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(access_token)
+}
+
+func (h *AuthHandler) GetAccessTokens(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	access_tokens, err := postgres.SelectAccessTokens(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select access tokens error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(access_tokens)
+}
+
+func (h *AuthHandler) DeleteAccessToken(w http.ResponseWriter, r *http.Request) {
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	rows_affected, err := postgres.RemoveAccessToken(h.DB, db_ctx, user_id, id)
+	if err != nil {
+		h.Logger.Error("postgres: delete access token error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if rows_affected == 0 {
+		h.Logger.Warn("postgres: access token was not found", "id", id)
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	h.Logger.Info("Access token was revoked", "user", user_id, "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
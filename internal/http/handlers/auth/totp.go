@@ -0,0 +1,377 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	redis_ "todo/internal/storage/redis"
+	"todo/internal/utils/session"
+	"todo/internal/utils/token"
+	"todo/internal/utils/totp"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	totpIssuer        = "todo"
+	enrollmentTTL     = 10 * time.Minute
+	pendingLoginTTL   = 5 * time.Minute
+	maxLoginFailures  = 5
+	recoveryCodeCount = 10
+	// A used code stays refused for as long as it could still be accepted
+	usedStepTTL = (2*totp.Skew + 1) * totp.Period * time.Second
+)
+
+// challenge answers a correct password with a pending login instead of a
+// session, to be completed at POST /login/2fa.
+func (h *AuthHandler) challenge(w http.ResponseWriter, r *http.Request, user_id int, email string) {
+	login_token, err := token.Generate(32)
+	if err != nil {
+		h.Logger.Error("login token generation error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cache_cancel()
+
+	err = redis_.StorePendingLogin(h.Cache, cache_ctx, token.Hash(login_token), user_id, pendingLoginTTL)
+	if err != nil {
+		h.Logger.Error("redis: store pending login error", "err", err)
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("Password accepted, second factor required", "user", email)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(models.LoginChallenge{
+		Two_factor: true,
+		Token:      login_token,
+		Expires_in: int(pendingLoginTTL.Seconds()),
+	})
+}
+
+// LoginTOTP completes a pending login with a TOTP code or a recovery code.
+// The pending login is dropped after maxLoginFailures wrong codes, and each
+// wrong code also counts against the account like a wrong password, so new
+// pending logins don't give new tries.
+func (h *AuthHandler) LoginTOTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	defer r.Body.Close()
+
+	var login models.LoginTOTP
+
+	err := json.NewDecoder(r.Body).Decode(&login)
+	if err != nil || login.Token == "" || (login.Code == "") == (login.Recovery_code == "") {
+		h.Logger.Error("Login 2fa error", "err", err)
+		http.Error(w, "Bad request: a token and either a code or a recovery_code are needed", http.StatusBadRequest)
+		return
+	}
+
+	token_hash := token.Hash(login.Token)
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer cache_cancel()
+
+	user_id, err := redis_.GetPendingLogin(h.Cache, cache_ctx, token_hash)
+	if err == redis.Nil {
+		h.Logger.Warn("Invalid or expired pending login")
+		http.Error(w, "Invalid or expired login", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("redis: get pending login error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	user, err := postgres.SelectUser(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select user error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	throttle_keys := loginThrottleKeys(user.Email, session.GetIP(r))
+
+	if h.lockedOut(w, r, throttle_keys) {
+		return
+	}
+
+	valid := false
+
+	if login.Code != "" {
+		valid, err = h.checkCode(cache_ctx, db_ctx, user_id, login.Code)
+	} else {
+		code_hash := token.Hash(totp.NormalizeRecoveryCode(login.Recovery_code))
+		valid, err = postgres.UseRecoveryCode(h.DB, db_ctx, user_id, code_hash)
+	}
+	if err != nil {
+		h.Logger.Error("Second factor check error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !valid {
+		failures, err := redis_.CountPendingLoginFailure(h.Cache, cache_ctx, token_hash, pendingLoginTTL)
+		if err == nil && failures >= maxLoginFailures {
+			err = redis_.DeletePendingLogin(h.Cache, cache_ctx, token_hash)
+		}
+		if err != nil {
+			h.Logger.Error("redis: pending login failure error", "err", err)
+		}
+
+		h.Logger.Warn("Invalid second factor for user", "user", user.Email, "failures", failures)
+
+		if lock := h.recordLoginFailure(r, throttle_keys); lock > 0 {
+			tooManyAttempts(w, lock)
+			return
+		}
+
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	err = redis_.DeletePendingLogin(h.Cache, cache_ctx, token_hash)
+	if err != nil {
+		h.Logger.Error("redis: delete pending login error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if login.Recovery_code != "" {
+		h.Logger.Info("Recovery code used", "user", user.Email)
+	}
+
+	h.loginSucceeded(r, throttle_keys)
+	h.startSession(w, r, user_id, user.Email)
+}
+
+// checkCode checks a code against the enabled secret, refusing a code that
+// was already used.
+func (h *AuthHandler) checkCode(cache_ctx context.Context, db_ctx context.Context, user_id int, code string) (bool, error) {
+	secret, err := postgres.GetTOTPSecret(h.DB, db_ctx, user_id)
+	if err != nil || secret == "" {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return redis_.UseTOTPStep(h.Cache, cache_ctx, user_id, step, usedStepTTL)
+}
+
+// EnrollTOTP starts setting up two-factor with a new secret, returned along
+// with the otpauth URI to show as a QR code. Two-factor is only enabled once
+// ConfirmTOTP gets a first code.
+func (h *AuthHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	user, err := postgres.SelectUser(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select user error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if user.Two_factor {
+		h.Logger.Warn("Two-factor already enabled", "user", user.Email)
+		http.Error(w, "Two-factor already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		h.Logger.Error("totp secret generation error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cache_cancel()
+
+	err = redis_.StoreTOTPEnrollment(h.Cache, cache_ctx, user_id, secret, enrollmentTTL)
+	if err != nil {
+		h.Logger.Error("redis: store totp enrollment error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("Two-factor enrollment started", "user", user.Email)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+// ConfirmTOTP enables two-factor with the first code of the enrolled secret,
+// and returns the recovery codes. They are only ever shown here.
+func (h *AuthHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var confirm models.TOTPCode
+
+	err := json.NewDecoder(r.Body).Decode(&confirm)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cache_cancel()
+
+	secret, err := redis_.GetTOTPEnrollment(h.Cache, cache_ctx, user_id)
+	if err == redis.Nil {
+		h.Logger.Warn("No two-factor enrollment in progress", "user", user_id)
+		http.Error(w, "No enrollment in progress", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("redis: get totp enrollment error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	step, ok := totp.Validate(secret, confirm.Code, time.Now())
+	if !ok {
+		h.Logger.Warn("Invalid two-factor confirmation code", "user", user_id)
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := totp.RecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		h.Logger.Error("recovery code generation error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	code_hashes := make([]string, len(codes))
+
+	for i, code := range codes {
+		code_hashes[i] = token.Hash(totp.NormalizeRecoveryCode(code))
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	err = postgres.EnableTOTP(h.DB, db_ctx, user_id, secret, code_hashes)
+	if err == sql.ErrNoRows {
+		h.Logger.Warn("Two-factor already enabled", "user", user_id)
+		http.Error(w, "Two-factor already enabled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("postgres: enable totp error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The confirmation code can't be replayed at login
+	if _, err := redis_.UseTOTPStep(h.Cache, cache_ctx, user_id, step, usedStepTTL); err != nil {
+		h.Logger.Error("redis: use totp step error", "err", err)
+	}
+
+	if err := redis_.DeleteTOTPEnrollment(h.Cache, cache_ctx, user_id); err != nil {
+		h.Logger.Error("redis: delete totp enrollment error", "err", err)
+	}
+
+	h.Logger.Info("Two-factor enabled", "user", user_id)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.RecoveryCodes{Codes: codes})
+}
+
+// DisableTOTP turns two-factor off after checking the password again, and
+// drops the recovery codes.
+func (h *AuthHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var disable models.DisableTOTP
+
+	err := json.NewDecoder(r.Body).Decode(&disable)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	user, err := postgres.SelectUser(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select user error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = session.ConfirmIdentity(r, user.Password, disable.Password)
+	if err != nil {
+		h.Logger.Warn("Identity not confirmed for user", "user", user.Email, "err", err)
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if !user.Two_factor {
+		h.Logger.Warn("Two-factor not enabled", "user", user.Email)
+		http.Error(w, "Two-factor not enabled", http.StatusConflict)
+		return
+	}
+
+	err = postgres.DisableTOTP(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: disable totp error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("Two-factor disabled", "user", user.Email)
+	w.WriteHeader(http.StatusNoContent)
+}
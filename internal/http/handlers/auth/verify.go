@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/mail"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	redis_ "todo/internal/storage/redis"
+	"todo/internal/utils/token"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	verifyTokenTTL = 24 * time.Hour
+	resendInterval = time.Minute
+)
+
+func (h *AuthHandler) sendVerification(ctx context.Context, user_id int, email string) error {
+	verify_token, err := token.Generate(32)
+	if err != nil {
+		return err
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(ctx, time.Second)
+	defer cache_cancel()
+
+	err = redis_.StoreVerifyToken(h.Cache, cache_ctx, token.Hash(verify_token), user_id, verifyTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	mail.SendBackground(h.Mailer, h.Logger, mail.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: "Use this to verify the email address of your account within " + verifyTokenTTL.String() + ":\n\n" +
+			mail.Link(h.VerifyURL, verify_token) + "\n\n" +
+			"If you didn't sign up, you can ignore this mail.\n",
+	})
+
+	return nil
+}
+
+// VerifyEmail marks the email of the account as verified with a token from
+// the verification mail. Sessions already open are updated in place.
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	defer r.Body.Close()
+
+	var verify models.VerifyEmail
+
+	err := json.NewDecoder(r.Body).Decode(&verify)
+	if err != nil || verify.Token == "" {
+		h.Logger.Error("Verify email error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer cache_cancel()
+
+	user_id, err := redis_.GetDeleteVerifyToken(h.Cache, cache_ctx, token.Hash(verify.Token))
+	if err == redis.Nil {
+		h.Logger.Warn("Invalid or expired verification token")
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("redis: get verification token error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	err = postgres.SetEmailVerified(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: set email verified error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = redis_.MarkSessionsVerified(h.Cache, cache_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("redis: mark sessions verified error", "err", err)
+	}
+
+	h.Logger.Info("Email verified", "user", user_id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendVerification mails a new verification link to the logged in user,
+// at most once per resendInterval.
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	user, err := postgres.SelectUser(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select user error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if user.Email_verified_at != nil {
+		h.Logger.Warn("Email already verified", "user", user_id)
+		http.Error(w, "Email already verified", http.StatusConflict)
+		return
+	}
+
+	cache_ctx, cache_cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cache_cancel()
+
+	allowed, retry_after, err := redis_.AllowVerifyResend(h.Cache, cache_ctx, user_id, resendInterval)
+	if err != nil {
+		h.Logger.Error("redis: verification resend limit error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !allowed {
+		h.Logger.Warn("Verification resend rate limited", "user", user_id)
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry_after.Seconds()+0.5)))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	err = h.sendVerification(r.Context(), user_id, user.Email)
+	if err != nil {
+		h.Logger.Error("Verification mail error", "user", user.Email, "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("Verification mail resent", "user", user.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
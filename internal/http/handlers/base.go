@@ -2,14 +2,20 @@ package handlers
 
 import (
 	"net/http"
+	"todo/internal/http/handlers/account"
 	"todo/internal/http/handlers/auth"
+	"todo/internal/http/handlers/calendar"
+	"todo/internal/http/handlers/dav"
 	"todo/internal/http/handlers/todo"
 )
 
 type BaseHandler struct {
-	AuthHandler  *auth.AuthHandler
-	TasksHandler *todo.TasksHandler
-	Mux          *http.ServeMux
+	AuthHandler     *auth.AuthHandler
+	TasksHandler    *todo.TasksHandler
+	CalendarHandler *calendar.CalendarHandler
+	DAVHandler      *dav.DAVHandler
+	AccountHandler  *account.AccountHandler
+	Mux             *http.ServeMux
 }
 
 func (h *BaseHandler) HandleRoutes() {
@@ -19,10 +25,71 @@ func (h *BaseHandler) HandleRoutes() {
 
 	h.Mux.HandleFunc("GET /tasks", h.TasksHandler.GetTasks)
 	h.Mux.HandleFunc("POST /tasks", h.TasksHandler.PostTask)
+	h.Mux.HandleFunc("POST /tasks/quick", h.TasksHandler.PostQuickTask)
+	h.Mux.HandleFunc("GET /tasks/views", h.TasksHandler.GetViewCounts)
+	h.Mux.HandleFunc("GET /tasks/views/{view}", h.TasksHandler.GetViewTasks)
+	h.Mux.HandleFunc("GET /tasks/export.csv", h.TasksHandler.ExportTasksCSV)
+	h.Mux.HandleFunc("POST /tasks/import", h.TasksHandler.ImportTasksCSV)
+	h.Mux.HandleFunc("GET /tasks/export.txt", h.TasksHandler.ExportTasksTodoTxt)
+	h.Mux.HandleFunc("POST /tasks/import.txt", h.TasksHandler.ImportTasksTodoTxt)
 	h.Mux.HandleFunc("GET /tasks/{id}", h.TasksHandler.GetTask)
 	h.Mux.HandleFunc("PATCH /tasks/{id}", h.TasksHandler.PatchTask)
 	h.Mux.HandleFunc("DELETE /tasks/{id}", h.TasksHandler.DeleteTask)
+	h.Mux.HandleFunc("POST /tasks/{id}/move", h.TasksHandler.MoveTask)
+	h.Mux.HandleFunc("GET /board", h.TasksHandler.GetBoard)
+	h.Mux.HandleFunc("GET /statuses", h.TasksHandler.GetStatuses)
+	h.Mux.HandleFunc("POST /statuses", h.TasksHandler.PostStatus)
+	h.Mux.HandleFunc("PUT /statuses/order", h.TasksHandler.OrderStatuses)
+	h.Mux.HandleFunc("PATCH /statuses/{id}", h.TasksHandler.PatchStatus)
+	h.Mux.HandleFunc("DELETE /statuses/{id}", h.TasksHandler.DeleteStatus)
+	h.Mux.HandleFunc("GET /templates", h.TasksHandler.GetTemplates)
+	h.Mux.HandleFunc("POST /templates", h.TasksHandler.PostTemplate)
+	h.Mux.HandleFunc("GET /templates/{id}", h.TasksHandler.GetTemplate)
+	h.Mux.HandleFunc("PATCH /templates/{id}", h.TasksHandler.PatchTemplate)
+	h.Mux.HandleFunc("DELETE /templates/{id}", h.TasksHandler.DeleteTemplate)
+	h.Mux.HandleFunc("POST /templates/{id}/instantiate", h.TasksHandler.InstantiateTemplate)
+	h.Mux.HandleFunc("GET /stats", h.TasksHandler.GetStats)
+	h.Mux.HandleFunc("GET /filters", h.TasksHandler.GetFilters)
+	h.Mux.HandleFunc("POST /filters", h.TasksHandler.PostFilter)
+	h.Mux.HandleFunc("PUT /filters/order", h.TasksHandler.OrderFilters)
+	h.Mux.HandleFunc("GET /filters/{id}", h.TasksHandler.GetFilter)
+	h.Mux.HandleFunc("PATCH /filters/{id}", h.TasksHandler.PatchFilter)
+	h.Mux.HandleFunc("DELETE /filters/{id}", h.TasksHandler.DeleteFilter)
+	h.Mux.HandleFunc("GET /filters/{id}/tasks", h.TasksHandler.GetFilterTasks)
 	h.Mux.HandleFunc("POST /register", h.AuthHandler.Register)
 	h.Mux.HandleFunc("POST /login", h.AuthHandler.Login)
+	h.Mux.HandleFunc("POST /login/2fa", h.AuthHandler.LoginTOTP)
+	h.Mux.HandleFunc("GET /oidc/login", h.AuthHandler.OIDCLogin)
+	h.Mux.HandleFunc("GET /oidc/callback", h.AuthHandler.OIDCCallback)
 	h.Mux.HandleFunc("POST /logout", h.AuthHandler.Logout)
+	h.Mux.HandleFunc("POST /admin/unlock-login", h.AuthHandler.UnlockLogin)
+	h.Mux.HandleFunc("GET /sessions", h.AuthHandler.GetSessions)
+	h.Mux.HandleFunc("DELETE /sessions", h.AuthHandler.DeleteSessions)
+	h.Mux.HandleFunc("DELETE /sessions/{id}", h.AuthHandler.DeleteSession)
+	h.Mux.HandleFunc("GET /csrf", h.AuthHandler.GetCSRFToken)
+	h.Mux.HandleFunc("GET /tokens", h.AuthHandler.GetAccessTokens)
+	h.Mux.HandleFunc("POST /tokens", h.AuthHandler.CreateAccessToken)
+	h.Mux.HandleFunc("DELETE /tokens/{id}", h.AuthHandler.DeleteAccessToken)
+	h.Mux.HandleFunc("POST /2fa/enroll", h.AuthHandler.EnrollTOTP)
+	h.Mux.HandleFunc("POST /2fa/confirm", h.AuthHandler.ConfirmTOTP)
+	h.Mux.HandleFunc("POST /2fa/disable", h.AuthHandler.DisableTOTP)
+	h.Mux.HandleFunc("POST /password/forgot", h.AuthHandler.ForgotPassword)
+	h.Mux.HandleFunc("POST /password/reset", h.AuthHandler.ResetPassword)
+	h.Mux.HandleFunc("POST /email/verify", h.AuthHandler.VerifyEmail)
+	h.Mux.HandleFunc("POST /email/verify/resend", h.AuthHandler.ResendVerification)
+	h.Mux.HandleFunc("POST /calendar/token", h.CalendarHandler.CreateFeedToken)
+	h.Mux.HandleFunc("DELETE /calendar/token", h.CalendarHandler.RevokeFeedToken)
+	h.Mux.HandleFunc("GET /calendar.ics", h.CalendarHandler.Feed)
+	h.Mux.HandleFunc("POST /app-passwords", h.DAVHandler.CreateAppPassword)
+	h.Mux.HandleFunc("GET /app-passwords", h.DAVHandler.GetAppPasswords)
+	h.Mux.HandleFunc("DELETE /app-passwords/{id}", h.DAVHandler.DeleteAppPassword)
+	h.Mux.HandleFunc("/.well-known/caldav", h.DAVHandler.WellKnown)
+	h.Mux.HandleFunc(dav.RootPath, h.DAVHandler.ServeDAV)
+	h.Mux.HandleFunc("GET /me", h.AccountHandler.GetProfile)
+	h.Mux.HandleFunc("PATCH /me", h.AccountHandler.PatchProfile)
+	h.Mux.HandleFunc("POST /me/password", h.AccountHandler.ChangePassword)
+	h.Mux.HandleFunc("POST /me/email", h.AccountHandler.ChangeEmail)
+	h.Mux.HandleFunc("POST /email/confirm", h.AccountHandler.ConfirmEmail)
+	h.Mux.HandleFunc("GET /me/export", h.AccountHandler.Export)
+	h.Mux.HandleFunc("DELETE /me", h.AccountHandler.DeleteAccount)
 }
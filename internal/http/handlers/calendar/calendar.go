@@ -0,0 +1,173 @@
+package calendar
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/ical"
+	"todo/internal/utils/task"
+	"todo/internal/utils/token"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const FeedPath = "/calendar.ics"
+
+type CalendarHandler struct {
+	DB     *sql.DB
+	Cache  *redis.Client
+	Logger *slog.Logger
+}
+
+func (h *CalendarHandler) CreateFeedToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	feed_token, err := token.Generate(32)
+	if err != nil {
+		h.Logger.Error("feed token generation error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	// Issuing a new token replaces the old one, which revokes every URL handed out before
+	err = postgres.SetFeedToken(h.DB, db_ctx, user_id, token.Hash(feed_token))
+	if err != nil {
+		h.Logger.Error("postgres: set feed token error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("Calendar feed token issued", "user", user_id)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.FeedToken{
+		Token: feed_token,
+		URL:   FeedPath + "?token=" + url.QueryEscape(feed_token),
+	})
+}
+
+func (h *CalendarHandler) RevokeFeedToken(w http.ResponseWriter, r *http.Request) {
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	rows_affected, err := postgres.RemoveFeedToken(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: remove feed token error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if rows_affected == 0 {
+		h.Logger.Warn("postgres: feed token was not found", "user", user_id)
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	h.Logger.Info("Calendar feed token revoked", "user", user_id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Feed is a public route: calendar clients can't send the session cookie,
+// so the user is resolved from the secret token in the URL instead.
+func (h *CalendarHandler) Feed(w http.ResponseWriter, r *http.Request) {
+	feed_token := r.URL.Query().Get("token")
+	if feed_token == "" {
+		h.Logger.Warn("feed token missing")
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	user_id, err := postgres.GetFeedTokenUserID(h.DB, db_ctx, token.Hash(feed_token))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			h.Logger.Error("postgres: get feed token error", "err", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		h.Logger.Warn("feed token not found")
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	with_events := false
+
+	if param := r.URL.Query().Get("events"); param != "" {
+		with_events, err = strconv.ParseBool(param)
+		if err != nil {
+			h.Logger.Error("events param not a bool value", "err", err)
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	timezone, err := postgres.GetUserTimezone(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: get user timezone error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		h.Logger.Error("calendar: user timezone error", "timezone", timezone, "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	query_params, args, err := task_utils.GetDynamicQuery(user_id, loc, r)
+	if err != nil {
+		h.Logger.Error("dynamic query error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	tasks, err := postgres.SelectDBTasks(h.DB, db_ctx, query_params, args)
+	if err != nil {
+		h.Logger.Error("postgres: select tasks error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="tasks.ics"`)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.WriteHeader(http.StatusOK)
+
+	err = ical_utils.WriteCalendar(w, "Tasks", tasks, with_events)
+	if err != nil {
+		h.Logger.Error("calendar feed write error", "err", err)
+	}
+}
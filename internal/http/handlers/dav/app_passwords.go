@@ -0,0 +1,135 @@
+package dav
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/task"
+	"todo/internal/utils/token"
+)
+
+func (h *DAVHandler) CreateAppPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var new_app_password models.AppPassword
+
+	err := json.NewDecoder(r.Body).Decode(&new_app_password)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(new_app_password.Name)
+
+	if name == "" || len(name) > 100 || !task_utils.ValidString(name) {
+		h.Logger.Error("validate: app password name validation error", "name", name)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := token.Generate(18)
+	if err != nil {
+		h.Logger.Error("app password generation error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	app_password, err := postgres.CreateAppPassword(h.DB, db_ctx, user_id, name, token.Hash(secret))
+	if err != nil {
+		h.Logger.Error("postgres: create app password error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The plain password is only ever shown in this response
+	app_password.Password = secret
+
+	h.Logger.Info("App password was created", "user", user_id, "id", app_password.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(app_password)
+}
+
+func (h *DAVHandler) GetAppPasswords(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	app_passwords, err := postgres.SelectAppPasswords(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select app passwords error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(app_passwords)
+}
+
+func (h *DAVHandler) DeleteAppPassword(w http.ResponseWriter, r *http.Request) {
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		h.Logger.Error("request: app password id not a number", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	rows_affected, err := postgres.RemoveAppPassword(h.DB, db_ctx, user_id, id)
+	if err != nil {
+		h.Logger.Error("postgres: delete app password error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if rows_affected == 0 {
+		h.Logger.Warn("postgres: app password was not found", "id", id)
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	h.Logger.Info("App password was revoked", "user", user_id, "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
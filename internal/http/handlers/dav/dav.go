@@ -0,0 +1,477 @@
+package dav
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/dav"
+	"todo/internal/utils/ical"
+	"todo/internal/utils/task"
+	"todo/internal/utils/token"
+	"todo/internal/utils/validators"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The server exposes a single task collection per user. Every path is
+// relative to the authenticated user, so "me" stands in for the principal.
+const (
+	RootPath       = "/dav/"
+	PrincipalPath  = "/dav/principals/me/"
+	HomePath       = "/dav/calendars/me/"
+	CollectionPath = "/dav/calendars/me/tasks/"
+)
+
+const maxObjectSize = 1 << 20
+
+type DAVHandler struct {
+	DB     *sql.DB
+	Cache  *redis.Client
+	Logger *slog.Logger
+}
+
+func (h *DAVHandler) WellKnown(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, RootPath, http.StatusMovedPermanently)
+}
+
+// ServeDAV dispatches the CalDAV methods itself, since the mux can't route
+// on WebDAV methods together with the resource hierarchy.
+func (h *DAVHandler) ServeDAV(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		w.Header().Set("DAV", "1, 3, calendar-access")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	user_id, ok := h.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="todo", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case "PROPFIND":
+		h.propfind(w, r, user_id)
+	case "REPORT":
+		h.report(w, r, user_id)
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r, user_id)
+	case http.MethodPut:
+		h.put(w, r, user_id)
+	case http.MethodDelete:
+		h.delete(w, r, user_id)
+	default:
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *DAVHandler) authenticate(r *http.Request) (int, bool) {
+	email, app_password, ok := r.BasicAuth()
+	if !ok || email == "" || app_password == "" {
+		h.Logger.Warn("dav: basic auth missing")
+		return 0, false
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	user_id, err := postgres.AuthenticateAppPassword(h.DB, db_ctx, email, token.Hash(app_password))
+	if err != nil {
+		h.Logger.Warn("dav: app password authentication failed", "user", email, "err", err)
+		return 0, false
+	}
+
+	return user_id, true
+}
+
+func (h *DAVHandler) propfind(w http.ResponseWriter, r *http.Request, user_id int) {
+	defer r.Body.Close()
+
+	propfind, err := dav_utils.ParsePropfind(http.MaxBytesReader(w, r.Body, maxObjectSize))
+	if err != nil {
+		h.Logger.Error("dav: propfind parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	depth_one := r.Header.Get("Depth") != "0"
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	var resources []resource
+
+	switch r.URL.Path {
+	case RootPath:
+		resources = append(resources, resource{href: RootPath, kind: kindRoot})
+		if depth_one {
+			resources = append(resources, resource{href: PrincipalPath, kind: kindPrincipal})
+		}
+	case PrincipalPath:
+		resources = append(resources, resource{href: PrincipalPath, kind: kindPrincipal})
+	case HomePath:
+		resources = append(resources, resource{href: HomePath, kind: kindHome})
+		if depth_one {
+			collection, err := h.collection(db_ctx, user_id)
+			if err != nil {
+				h.Logger.Error("postgres: select dav tasks error", "err", err)
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+			resources = append(resources, collection)
+		}
+	case CollectionPath:
+		collection, err := h.collection(db_ctx, user_id)
+		if err != nil {
+			h.Logger.Error("postgres: select dav tasks error", "err", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		resources = append(resources, collection)
+		if depth_one {
+			for i := range collection.tasks {
+				resources = append(resources, taskResource(&collection.tasks[i]))
+			}
+		}
+	default:
+		name, ok := taskName(r.URL.Path)
+		if !ok {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		task, err := postgres.SelectDAVTask(h.DB, db_ctx, user_id, name)
+		if err != nil {
+			h.writeLookupError(w, err)
+			return
+		}
+
+		resources = append(resources, taskResource(&task))
+	}
+
+	var multistatus dav_utils.Multistatus
+
+	for _, res := range resources {
+		multistatus.Add(res.response(propfind.PropNames(), false))
+	}
+
+	writeMultistatus(w, multistatus)
+}
+
+func (h *DAVHandler) report(w http.ResponseWriter, r *http.Request, user_id int) {
+	defer r.Body.Close()
+
+	if r.URL.Path != CollectionPath {
+		http.Error(w, "Reports are supported on the task collection only", http.StatusForbidden)
+		return
+	}
+
+	report, err := dav_utils.ParseReport(http.MaxBytesReader(w, r.Body, maxObjectSize))
+	if err != nil {
+		h.Logger.Error("dav: report parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	var multistatus dav_utils.Multistatus
+
+	switch {
+	case report.IsMultiget():
+		for _, href := range report.Hrefs {
+			href = strings.TrimSpace(href)
+
+			name, ok := taskName(hrefPath(href))
+			if !ok {
+				multistatus.Add(dav_utils.Response{Href: href, Status: http.StatusNotFound})
+				continue
+			}
+
+			task, err := postgres.SelectDAVTask(h.DB, db_ctx, user_id, name)
+			if err == sql.ErrNoRows {
+				multistatus.Add(dav_utils.Response{Href: href, Status: http.StatusNotFound})
+				continue
+			}
+			if err != nil {
+				h.Logger.Error("postgres: select dav task error", "err", err)
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+
+			multistatus.Add(taskResource(&task).response(report.PropNames(), true))
+		}
+	case report.IsQuery():
+		tasks, err := postgres.SelectDAVTasks(h.DB, db_ctx, user_id)
+		if err != nil {
+			h.Logger.Error("postgres: select dav tasks error", "err", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		for i := range tasks {
+			if report.Filter != nil && !matchFilter(report.Filter.CompFilter, tasks[i]) {
+				continue
+			}
+
+			multistatus.Add(taskResource(&tasks[i]).response(report.PropNames(), true))
+		}
+	default:
+		h.Logger.Warn("dav: unsupported report", "report", report.XMLName.Local)
+		http.Error(w, "Unsupported report", http.StatusForbidden)
+		return
+	}
+
+	writeMultistatus(w, multistatus)
+}
+
+func (h *DAVHandler) get(w http.ResponseWriter, r *http.Request, user_id int) {
+	name, ok := taskName(r.URL.Path)
+	if !ok {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	task, err := postgres.SelectDAVTask(h.DB, db_ctx, user_id, name)
+	if err != nil {
+		h.writeLookupError(w, err)
+		return
+	}
+
+	etag := ETag(task)
+
+	w.Header().Set("ETag", etag)
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	ical_utils.WriteTodoCalendar(w, taskUID(task), task.DBtask)
+}
+
+func (h *DAVHandler) put(w http.ResponseWriter, r *http.Request, user_id int) {
+	defer r.Body.Close()
+
+	name, ok := taskName(r.URL.Path)
+	if !ok {
+		http.Error(w, "Objects can only be stored in the task collection", http.StatusForbidden)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	timezone, err := postgres.GetUserTimezone(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: get user timezone error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		h.Logger.Error("dav: user timezone error", "timezone", timezone, "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	todo, err := ical_utils.ParseTodo(http.MaxBytesReader(w, r.Body, maxObjectSize), loc)
+	if err != nil {
+		h.Logger.Error("dav: calendar object parsing error", "err", err)
+		http.Error(w, "Unsupported calendar data: "+err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	current, err := postgres.SelectDAVTask(h.DB, db_ctx, user_id, name)
+	if err != nil && err != sql.ErrNoRows {
+		h.Logger.Error("postgres: select dav task error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	exists := err == nil
+
+	if !preconditionsMet(r, exists, current) {
+		h.Logger.Warn("dav: put precondition failed", "name", name)
+		http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	status := http.StatusCreated
+
+	if exists {
+		status = http.StatusNoContent
+
+		update_task, err := taskUpdate(current, todo)
+		if err == nil {
+			err = validators.ValidateUpdateTask(h.DB, db_ctx, user_id, loc, update_task)
+		}
+		if err != nil {
+			h.Logger.Warn("dav: calendar object rejected", "name", name, "err", err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		update_query, args := task_utils.GetUpdateQuery(user_id, current.ID, update_task)
+
+		if update_query != "" {
+			err = postgres.UpdateTask(h.DB, db_ctx, update_query, args)
+		}
+	} else {
+		new_task, err := newTask(todo)
+		if err == nil {
+			err = validators.ValidateTask(h.DB, db_ctx, user_id, loc, new_task)
+		}
+		if err != nil {
+			h.Logger.Warn("dav: calendar object rejected", "name", name, "err", err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		err = postgres.InsertDAVTask(h.DB, db_ctx, user_id, name, todo.UID, new_task, todo.Completed)
+		if errors.Is(err, postgres.ErrWIPLimit) {
+			h.Logger.Warn("dav: calendar object rejected", "name", name, "err", err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	if err != nil {
+		h.Logger.Error("postgres: dav task write error", "name", name, "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	stored, err := postgres.SelectDAVTask(h.DB, db_ctx, user_id, name)
+	if err == nil {
+		w.Header().Set("ETag", ETag(stored))
+	}
+
+	h.Logger.Info("dav: task stored", "name", name, "created", !exists)
+	w.WriteHeader(status)
+}
+
+func (h *DAVHandler) delete(w http.ResponseWriter, r *http.Request, user_id int) {
+	name, ok := taskName(r.URL.Path)
+	if !ok {
+		http.Error(w, "Collections can't be deleted", http.StatusForbidden)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	current, err := postgres.SelectDAVTask(h.DB, db_ctx, user_id, name)
+	if err != nil {
+		h.writeLookupError(w, err)
+		return
+	}
+
+	if !preconditionsMet(r, true, current) {
+		h.Logger.Warn("dav: delete precondition failed", "name", name)
+		http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	_, err = postgres.RemoveTask(h.DB, db_ctx, user_id, current.ID)
+	if err != nil {
+		h.Logger.Error("postgres: delete task error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("dav: task deleted", "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *DAVHandler) collection(ctx context.Context, user_id int) (resource, error) {
+	tasks, err := postgres.SelectDAVTasks(h.DB, ctx, user_id)
+	if err != nil {
+		return resource{}, err
+	}
+
+	return resource{href: CollectionPath, kind: kindCollection, tasks: tasks}, nil
+}
+
+func (h *DAVHandler) writeLookupError(w http.ResponseWriter, err error) {
+	if err == sql.ErrNoRows {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	h.Logger.Error("postgres: select dav task error", "err", err)
+	http.Error(w, "Server error", http.StatusInternalServerError)
+}
+
+func preconditionsMet(r *http.Request, exists bool, current models.DAVtask) bool {
+	if r.Header.Get("If-None-Match") == "*" && exists {
+		return false
+	}
+
+	if_match := r.Header.Get("If-Match")
+	if if_match == "" {
+		return true
+	}
+
+	if !exists {
+		return false
+	}
+
+	return if_match == "*" || if_match == ETag(current)
+}
+
+// ETag changes whenever the task row is updated, since every update through
+// the API or CalDAV sets updated_at.
+func ETag(task models.DAVtask) string {
+	return `"` + token.Hash(task.ID + "|" + task.Updated_at)[:24] + `"`
+}
+
+func taskUID(task models.DAVtask) string {
+	if task.UID != "" {
+		return task.UID
+	}
+
+	return ical_utils.UID(task.ID)
+}
+
+func taskName(path string) (string, bool) {
+	name, ok := strings.CutPrefix(path, CollectionPath)
+	if !ok || name == "" || strings.Contains(name, "/") || !strings.HasSuffix(name, ".ics") {
+		return "", false
+	}
+
+	return name, true
+}
+
+func hrefPath(href string) string {
+	parsed, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+
+	return parsed.Path
+}
+
+func writeMultistatus(w http.ResponseWriter, multistatus dav_utils.Multistatus) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(multistatus.Encode()))
+}
@@ -0,0 +1,86 @@
+package dav
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"todo/internal/models"
+	"todo/internal/utils/ical"
+)
+
+// Clients such as Apple Reminders don't set categories, while the tasks
+// table requires one.
+const defaultCategory = "inbox"
+
+func newTask(todo ical_utils.Todo) (models.NewTask, error) {
+	due, err := dueDate(todo)
+	if err != nil {
+		return models.NewTask{}, err
+	}
+
+	return models.NewTask{
+		Title:    strings.TrimSpace(todo.Summary),
+		Due_date: due,
+		Priority: ical_utils.PriorityFromICal(todo.Priority),
+		Category: category(todo),
+	}, nil
+}
+
+// taskUpdate only sets the fields that differ from the stored task, so that
+// an unchanged title doesn't trip the uniqueness check and an overdue task
+// can still be completed.
+func taskUpdate(current models.DAVtask, todo ical_utils.Todo) (models.UpdateTask, error) {
+	var update_task models.UpdateTask
+
+	due, err := dueDate(todo)
+	if err != nil {
+		return update_task, err
+	}
+
+	if title := strings.TrimSpace(todo.Summary); title != current.Title {
+		update_task.Title = &title
+	}
+
+	current_due, err := time.Parse(time.RFC3339Nano, current.Due_date)
+	if err != nil || current_due.UTC().Format(time.RFC3339) != due {
+		update_task.Due_date = &due
+	}
+
+	if priority := ical_utils.PriorityFromICal(todo.Priority); priority != current.Priority {
+		update_task.Priority = &priority
+	}
+
+	if category := category(todo); category != current.Category {
+		update_task.Category = &category
+	}
+
+	if todo.Completed != current.Completed {
+		completed := todo.Completed
+		update_task.Completed = &completed
+	}
+
+	return update_task, nil
+}
+
+func dueDate(todo ical_utils.Todo) (string, error) {
+	if todo.Due == nil {
+		return "", errors.New("insertion requirements not met, VTODO must have a DUE date")
+	}
+
+	due := todo.Due.UTC()
+
+	// A date-only due is due by the end of that day
+	if todo.DateOnly {
+		due = due.Add(24*time.Hour - time.Second)
+	}
+
+	return due.Format(time.RFC3339), nil
+}
+
+func category(todo ical_utils.Todo) string {
+	if len(todo.Categories) == 0 {
+		return defaultCategory
+	}
+
+	return todo.Categories[0]
+}
@@ -0,0 +1,215 @@
+package dav
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+	"todo/internal/models"
+	"todo/internal/utils/dav"
+	"todo/internal/utils/ical"
+	"todo/internal/utils/token"
+)
+
+const (
+	kindRoot = iota
+	kindPrincipal
+	kindHome
+	kindCollection
+	kindTask
+)
+
+type resource struct {
+	href  string
+	kind  int
+	tasks []models.DAVtask
+	task  *models.DAVtask
+}
+
+func taskResource(task *models.DAVtask) resource {
+	return resource{href: CollectionPath + task.Name, kind: kindTask, task: task}
+}
+
+var (
+	propResourceType     = xml.Name{Space: dav_utils.NSDAV, Local: "resourcetype"}
+	propDisplayName      = xml.Name{Space: dav_utils.NSDAV, Local: "displayname"}
+	propCurrentPrincipal = xml.Name{Space: dav_utils.NSDAV, Local: "current-user-principal"}
+	propPrincipalURL     = xml.Name{Space: dav_utils.NSDAV, Local: "principal-URL"}
+	propOwner            = xml.Name{Space: dav_utils.NSDAV, Local: "owner"}
+	propPrivileges       = xml.Name{Space: dav_utils.NSDAV, Local: "current-user-privilege-set"}
+	propReportSet        = xml.Name{Space: dav_utils.NSDAV, Local: "supported-report-set"}
+	propETag             = xml.Name{Space: dav_utils.NSDAV, Local: "getetag"}
+	propContentType      = xml.Name{Space: dav_utils.NSDAV, Local: "getcontenttype"}
+	propLastModified     = xml.Name{Space: dav_utils.NSDAV, Local: "getlastmodified"}
+	propHomeSet          = xml.Name{Space: dav_utils.NSCalDAV, Local: "calendar-home-set"}
+	propComponentSet     = xml.Name{Space: dav_utils.NSCalDAV, Local: "supported-calendar-component-set"}
+	propCalendarData     = xml.Name{Space: dav_utils.NSCalDAV, Local: "calendar-data"}
+	propCTag             = xml.Name{Space: dav_utils.NSCS, Local: "getctag"}
+)
+
+// Properties returned for allprop requests, per resource kind.
+var defaultProps = map[int][]xml.Name{
+	kindRoot:       {propResourceType, propDisplayName, propCurrentPrincipal},
+	kindPrincipal:  {propResourceType, propDisplayName, propCurrentPrincipal, propPrincipalURL, propHomeSet},
+	kindHome:       {propResourceType, propDisplayName, propCurrentPrincipal, propOwner},
+	kindCollection: {propResourceType, propDisplayName, propCurrentPrincipal, propOwner, propComponentSet, propPrivileges, propReportSet, propCTag},
+	kindTask:       {propResourceType, propDisplayName, propETag, propContentType, propLastModified},
+}
+
+func (res resource) response(names []xml.Name, with_data bool) dav_utils.Response {
+	if names == nil {
+		names = defaultProps[res.kind]
+		if with_data && res.kind == kindTask {
+			names = append(names, propCalendarData)
+		}
+	}
+
+	response := dav_utils.Response{Href: res.href}
+
+	for _, name := range names {
+		inner, ok := res.prop(name)
+		if !ok {
+			response.NotFound = append(response.NotFound, name)
+			continue
+		}
+
+		response.Found = append(response.Found, dav_utils.Prop{Name: name, Inner: inner})
+	}
+
+	return response
+}
+
+func (res resource) prop(name xml.Name) (string, bool) {
+	switch name {
+	case propResourceType:
+		switch res.kind {
+		case kindPrincipal:
+			return "<d:collection/><d:principal/>", true
+		case kindCollection:
+			return "<d:collection/><c:calendar/>", true
+		case kindTask:
+			return "", true
+		default:
+			return "<d:collection/>", true
+		}
+	case propDisplayName:
+		switch res.kind {
+		case kindPrincipal:
+			return "me", true
+		case kindHome:
+			return "Calendars", true
+		case kindCollection:
+			return "Tasks", true
+		case kindTask:
+			return dav_utils.Escape(res.task.Title), true
+		default:
+			return "todo", true
+		}
+	case propCurrentPrincipal, propPrincipalURL, propOwner:
+		if res.kind == kindTask {
+			return "", false
+		}
+		return dav_utils.Href(PrincipalPath), true
+	case propHomeSet:
+		if res.kind == kindTask {
+			return "", false
+		}
+		return dav_utils.Href(HomePath), true
+	}
+
+	if res.kind == kindCollection {
+		switch name {
+		case propComponentSet:
+			return `<c:comp name="VTODO"/>`, true
+		case propPrivileges:
+			return "<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege>" +
+				"<d:privilege><d:write-content/></d:privilege><d:privilege><d:bind/></d:privilege>" +
+				"<d:privilege><d:unbind/></d:privilege>", true
+		case propReportSet:
+			return "<d:supported-report><d:report><c:calendar-query/></d:report></d:supported-report>" +
+				"<d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report>", true
+		case propCTag:
+			return CTag(res.tasks), true
+		}
+	}
+
+	if res.kind == kindTask {
+		switch name {
+		case propETag:
+			return dav_utils.Escape(ETag(*res.task)), true
+		case propContentType:
+			return "text/calendar; charset=utf-8; component=VTODO", true
+		case propLastModified:
+			modified, err := time.Parse(time.RFC3339Nano, res.task.Updated_at)
+			if err != nil {
+				return "", false
+			}
+			return modified.UTC().Format(http.TimeFormat), true
+		case propCalendarData:
+			var data strings.Builder
+			ical_utils.WriteTodoCalendar(&data, taskUID(*res.task), res.task.DBtask)
+			return dav_utils.Escape(data.String()), true
+		}
+	}
+
+	return "", false
+}
+
+// CTag changes whenever any task of the collection is added, updated or
+// removed, so clients know when to re-sync.
+func CTag(tasks []models.DAVtask) string {
+	var etags strings.Builder
+
+	for _, task := range tasks {
+		etags.WriteString(ETag(task))
+	}
+
+	return `"` + token.Hash(etags.String())[:24] + `"`
+}
+
+const timeRangeLayout = "20060102T150405Z"
+
+func matchFilter(filter dav_utils.CompFilter, task models.DAVtask) bool {
+	if filter.Name != "VCALENDAR" {
+		return false
+	}
+
+	if len(filter.CompFilters) == 0 {
+		return true
+	}
+
+	for _, comp := range filter.CompFilters {
+		if comp.Name == "VTODO" && matchTodoFilter(comp, task) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchTodoFilter(filter dav_utils.CompFilter, task models.DAVtask) bool {
+	for _, prop := range filter.PropFilters {
+		if prop.Name == "COMPLETED" && prop.IsNotDefined != nil && task.Completed {
+			return false
+		}
+	}
+
+	if filter.TimeRange == nil {
+		return true
+	}
+
+	due, err := time.Parse(time.RFC3339Nano, task.Due_date)
+	if err != nil {
+		return true
+	}
+
+	if start, err := time.Parse(timeRangeLayout, filter.TimeRange.Start); err == nil && due.Before(start) {
+		return false
+	}
+
+	if end, err := time.Parse(timeRangeLayout, filter.TimeRange.End); err == nil && !due.Before(end) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,88 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/task"
+)
+
+// GetBoard returns the user's tasks grouped by status, in status order. The
+// filters and sort of GetTasks apply within each column, and a column is
+// over its limit when it holds more tasks than its WIP limit.
+func (h *TasksHandler) GetBoard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	loc, err := h.location(db_ctx, user_id, r.URL.Query().Get("tz"))
+	if err != nil {
+		h.Logger.Error("request: timezone error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	query_params, args, err := task_utils.GetDynamicQuery(user_id, loc, r)
+	if err != nil {
+		h.Logger.Error("dynamic query error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	statuses, err := h.selectStatuses(db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select statuses error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	tasks, err := postgres.SelectTasks(h.DB, db_ctx, query_params, args)
+	if err != nil {
+		h.Logger.Error("postgres: select tasks error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	board := make([]models.BoardColumn, len(statuses))
+	columns := map[string]*models.BoardColumn{}
+
+	for i, status := range statuses {
+		board[i] = models.BoardColumn{Status: status, Tasks: []models.Task{}}
+		columns[status.Name] = &board[i]
+	}
+
+	for _, task := range tasks {
+		column, ok := columns[task.Status]
+		if !ok {
+			continue
+		}
+
+		task_utils.RenderTask(&task, loc)
+		column.Tasks = append(column.Tasks, task)
+	}
+
+	for i := range board {
+		board[i].Count = len(board[i].Tasks)
+
+		if limit := board[i].Status.WIP_limit; limit != nil {
+			board[i].Over_limit = board[i].Count > *limit
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(board)
+}
@@ -0,0 +1,102 @@
+package todo
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/csv"
+	"todo/internal/utils/task"
+)
+
+const flushEvery = 100
+
+func (h *TasksHandler) ExportTasksCSV(w http.ResponseWriter, r *http.Request) {
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer db_cancel()
+
+	loc, err := h.location(db_ctx, user_id, r.URL.Query().Get("tz"))
+	if err != nil {
+		h.Logger.Error("request: timezone error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	query_params, args, err := task_utils.GetDynamicQuery(user_id, loc, r)
+	if err != nil {
+		h.Logger.Error("dynamic query error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="tasks.csv"`)
+
+	writer := csv.NewWriter(w)
+	writer.Write(csv_utils.ExportHeader)
+
+	written := 0
+
+	err = postgres.StreamTasks(h.DB, db_ctx, query_params, args, func(task models.Task) error {
+		task_utils.RenderTask(&task, loc)
+
+		if err := writer.Write(csv_utils.TaskRecord(task)); err != nil {
+			return err
+		}
+
+		written++
+		if written%flushEvery == 0 {
+			writer.Flush()
+			return writer.Error()
+		}
+
+		return nil
+	})
+
+	writer.Flush()
+
+	if err != nil {
+		// Headers and part of the body may already be sent, so the error can only be logged
+		h.Logger.Error("postgres: export tasks error", "err", err, "rows", written)
+		return
+	}
+
+	h.Logger.Info("Tasks were exported", "rows", written)
+}
+
+func (h *TasksHandler) ImportTasksCSV(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	mapping, err := csv_utils.ParseMapping(r.URL.Query().Get("map"))
+	if err != nil {
+		h.Logger.Error("request: header mapping error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	h.importTasks(w, r, user_id, func(body io.Reader) ([]models.ImportTask, error) {
+		return csv_utils.ReadTasks(body, mapping)
+	})
+}
@@ -0,0 +1,380 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/task"
+	"todo/internal/utils/validators"
+)
+
+func (h *TasksHandler) GetFilters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	filters, err := postgres.SelectFilters(h.DB, db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select filters error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range filters {
+		checkFilter(&filters[i])
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(filters)
+}
+
+func (h *TasksHandler) PostFilter(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var new_filter models.NewFilter
+
+	err := json.NewDecoder(r.Body).Decode(&new_filter)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	new_filter.Name = strings.TrimSpace(new_filter.Name)
+
+	err = validators.ValidateFilterName(new_filter.Name)
+	if err == nil {
+		err = validators.ValidateFilter(new_filter.Params)
+	}
+	if err != nil {
+		h.Logger.Error("validate: filter validation error", "err", err)
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	if postgres.FilterExists(h.DB, db_ctx, user_id, new_filter.Name) {
+		h.Logger.Warn("Filter with provided name exists", "name", new_filter.Name)
+		http.Error(w, "Filter with provided name exists", http.StatusConflict)
+		return
+	}
+
+	filter, err := postgres.InsertFilter(h.DB, db_ctx, user_id, new_filter.Name, task_utils.EncodeFilter(new_filter.Params))
+	if err != nil {
+		h.Logger.Error("postgres: insert filter error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	checkFilter(&filter)
+
+	h.Logger.Info("Filter was created", "filter", filter.Name)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(filter)
+}
+
+func (h *TasksHandler) GetFilter(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	filter, ok := h.selectFilter(w, r, db_ctx, user_id)
+	if !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(filter)
+}
+
+func (h *TasksHandler) PatchFilter(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var update_filter models.UpdateFilter
+
+	err := json.NewDecoder(r.Body).Decode(&update_filter)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	filter, ok := h.selectFilter(w, r, db_ctx, user_id)
+	if !ok {
+		return
+	}
+
+	if update_filter.Name != nil {
+		name := strings.TrimSpace(*update_filter.Name)
+
+		if err := validators.ValidateFilterName(name); err != nil {
+			h.Logger.Error("validate: filter validation error", "err", err)
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if name != filter.Name && postgres.FilterExists(h.DB, db_ctx, user_id, name) {
+			h.Logger.Warn("Filter with provided name exists", "name", name)
+			http.Error(w, "Filter with provided name exists", http.StatusConflict)
+			return
+		}
+
+		filter.Name = name
+	}
+
+	if update_filter.Params != nil {
+		if err := validators.ValidateFilter(update_filter.Params); err != nil {
+			h.Logger.Error("validate: filter validation error", "err", err)
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		filter.Query = task_utils.EncodeFilter(update_filter.Params)
+	}
+
+	err = postgres.UpdateFilter(h.DB, db_ctx, user_id, filter)
+	if err != nil {
+		h.Logger.Error("postgres: update filter error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	filter, ok = h.selectFilter(w, r, db_ctx, user_id)
+	if !ok {
+		return
+	}
+
+	h.Logger.Info("Filter was updated", "filter", filter.Name)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(filter)
+}
+
+func (h *TasksHandler) DeleteFilter(w http.ResponseWriter, r *http.Request) {
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	rows_affected, err := postgres.RemoveFilter(h.DB, db_ctx, user_id, id)
+	if err != nil {
+		h.Logger.Error("postgres: delete filter error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if rows_affected == 0 {
+		h.Logger.Warn("postgres: filter was not found", "id", id)
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	h.Logger.Info("Filter was deleted", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// OrderFilters takes the ids of all the user's filters in their new order.
+func (h *TasksHandler) OrderFilters(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var order models.Order
+
+	err := json.NewDecoder(r.Body).Decode(&order)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	err = postgres.ReorderFilters(h.DB, db_ctx, user_id, order.IDs)
+	if err == postgres.ErrOrder {
+		h.Logger.Error("request: filter order error", "err", err)
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("postgres: reorder filters error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("Filters were reordered")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetFilterTasks runs a saved filter as a GET /tasks request. Only the tz
+// param of the request is used.
+func (h *TasksHandler) GetFilterTasks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	filter, ok := h.selectFilter(w, r, db_ctx, user_id)
+	if !ok {
+		return
+	}
+
+	if filter.Status != "ok" {
+		h.Logger.Warn("Broken filter", "filter", filter.Name, "err", filter.Error)
+		http.Error(w, "Broken filter: "+filter.Error, http.StatusUnprocessableEntity)
+		return
+	}
+
+	loc, err := h.location(db_ctx, user_id, r.URL.Query().Get("tz"))
+	if err != nil {
+		h.Logger.Error("request: timezone error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	query_params, args, err := task_utils.GetDynamicQuery(user_id, loc, task_utils.FilterRequest(filter.Params))
+	if err != nil {
+		h.Logger.Error("dynamic query error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	tasks, err := postgres.SelectTasks(h.DB, db_ctx, query_params, args)
+	if err != nil {
+		h.Logger.Error("postgres: select filter tasks error", "filter", filter.Name, "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range tasks {
+		task_utils.RenderTask(&tasks[i], loc)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tasks)
+}
+
+// selectFilter loads the filter of the id path value, or writes the error
+// response.
+func (h *TasksHandler) selectFilter(w http.ResponseWriter, r *http.Request, ctx context.Context, user_id int) (models.Filter, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return models.Filter{}, false
+	}
+
+	filter, err := postgres.SelectFilter(h.DB, ctx, user_id, id)
+	if err == sql.ErrNoRows {
+		h.Logger.Warn("postgres: filter was not found", "id", id)
+		http.Error(w, "Not found", http.StatusNotFound)
+		return filter, false
+	}
+	if err != nil {
+		h.Logger.Error("postgres: select filter error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return filter, false
+	}
+
+	checkFilter(&filter)
+
+	return filter, true
+}
+
+// checkFilter decodes the stored query of a filter and validates it again.
+func checkFilter(filter *models.Filter) {
+	params, err := task_utils.DecodeFilter(filter.Query)
+	if err == nil {
+		err = validators.ValidateFilter(params)
+	}
+
+	filter.Params = params
+	filter.Status = "ok"
+
+	if err != nil {
+		filter.Status = "broken"
+		filter.Error = err.Error()
+	}
+}
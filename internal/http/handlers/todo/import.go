@@ -0,0 +1,163 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/task"
+	"todo/internal/utils/validators"
+)
+
+const maxImportSize = 5 << 20
+
+// importTasks validates the parsed rows with the same rules as PostTask and
+// stores them according to the mode and dry_run query params:
+//
+//   - mode=skip (default) inserts every valid row and reports the rest
+//   - mode=atomic inserts nothing unless every row is valid
+//   - dry_run=true only reports what would happen
+//
+// Due dates without an offset are read in the tz param or the user's timezone.
+// New tasks land in the first open or done status, and rows that would take
+// it beyond its WIP limit fail, or fail the whole import in atomic mode.
+func (h *TasksHandler) importTasks(w http.ResponseWriter, r *http.Request, user_id int, parse func(io.Reader) ([]models.ImportTask, error)) {
+	w.Header().Set("Content-Type", "application/json")
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "skip"
+	}
+
+	if mode != "skip" && mode != "atomic" {
+		h.Logger.Error("request: import mode not in ('skip', 'atomic')", "mode", mode)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	dry_run := false
+
+	if param := r.URL.Query().Get("dry_run"); param != "" {
+		var err error
+
+		dry_run, err = strconv.ParseBool(param)
+		if err != nil {
+			h.Logger.Error("request: dry_run param not a bool value", "err", err)
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rows, err := parse(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		h.Logger.Error("request: import parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer db_cancel()
+
+	loc, err := h.location(db_ctx, user_id, r.URL.Query().Get("tz"))
+	if err != nil {
+		h.Logger.Error("request: timezone error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	report := models.ImportReport{DryRun: dry_run, Mode: mode, Total: len(rows)}
+
+	var valid []models.ImportTask
+	var valid_ind []int
+
+	seen := map[string]int{}
+
+	for _, row := range rows {
+		row.Task.Due_date = task_utils.NormalizeDue(row.Task.Due_date, loc)
+
+		row_report := models.ImportRowReport{Line: row.Line, Title: row.Task.Title, Status: "valid"}
+
+		err := validators.ValidateImportedTask(h.DB, db_ctx, user_id, loc, row)
+
+		if err == nil {
+			if line, ok := seen[row.Task.Title]; ok {
+				err = fmt.Errorf("unique task violation: duplicate of line %d", line)
+			}
+		}
+
+		if err != nil {
+			row_report.Status = "failed"
+			row_report.Error = err.Error()
+			report.Failed++
+		} else {
+			seen[row.Task.Title] = row.Line
+			valid = append(valid, row)
+			valid_ind = append(valid_ind, len(report.Rows))
+			report.Valid++
+		}
+
+		report.Rows = append(report.Rows, row_report)
+	}
+
+	if dry_run {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	if mode == "atomic" {
+		if report.Failed > 0 {
+			h.Logger.Warn("Import rejected", "failed", report.Failed)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(report)
+			return
+		}
+
+		err = postgres.InsertImportedTasks(h.DB, db_ctx, user_id, valid)
+		if errors.Is(err, errWIPLimit) {
+			h.Logger.Warn("Import rejected", "err", err)
+			http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			h.Logger.Error("postgres: import insertion error", "err", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		for _, ind := range valid_ind {
+			report.Rows[ind].Status = "imported"
+		}
+		report.Imported = len(valid)
+	} else {
+		for i, row := range valid {
+			row_report := &report.Rows[valid_ind[i]]
+
+			err = postgres.InsertImportedTask(h.DB, db_ctx, user_id, row)
+			if err != nil {
+				h.Logger.Error("postgres: import insertion error", "line", row_report.Line, "err", err)
+				row_report.Status = "failed"
+				row_report.Error = "insertion failed"
+				if errors.Is(err, errWIPLimit) {
+					row_report.Error = err.Error()
+				}
+				report.Failed++
+				report.Valid--
+				continue
+			}
+
+			row_report.Status = "imported"
+			report.Imported++
+		}
+	}
+
+	h.Logger.Info("Tasks were imported", "imported", report.Imported, "failed", report.Failed)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,67 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+)
+
+// MoveTask places a task right before or right after an anchor task, for
+// sort=position. Only the moved task is written.
+func (h *TasksHandler) MoveTask(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	task_uuid := r.PathValue("id")
+
+	var move_task models.MoveTask
+
+	err := json.NewDecoder(r.Body).Decode(&move_task)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	anchor_uuid, above := move_task.Before, false
+	if move_task.After != "" {
+		anchor_uuid, above = move_task.After, true
+	}
+
+	if (move_task.Before == "") == (move_task.After == "") || anchor_uuid == task_uuid {
+		h.Logger.Error("request: move needs one anchor other than the task")
+		http.Error(w, "Bad request: set either before or after to another task", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	err = postgres.MoveTaskPosition(h.DB, db_ctx, user_id, task_uuid, anchor_uuid, above)
+	if err == sql.ErrNoRows {
+		h.Logger.Warn("postgres: task was not found", "err", err)
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("postgres: move task error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("Task was moved")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,101 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/quickadd"
+	"todo/internal/utils/validators"
+)
+
+// PostQuickTask creates a task from a one-line description. With preview
+// set, it only returns the interpretation so the client can confirm it.
+func (h *TasksHandler) PostQuickTask(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var quick_add models.QuickAdd
+
+	err := json.NewDecoder(r.Body).Decode(&quick_add)
+	if err != nil || strings.TrimSpace(quick_add.Text) == "" {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	loc, err := h.location(db_ctx, user_id, quick_add.Timezone)
+	if err != nil {
+		h.Logger.Error("request: unknown timezone", "timezone", quick_add.Timezone, "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	result := quickadd.Parse(quick_add.Text, time.Now().In(loc))
+
+	quick_task := models.QuickTask{
+		Input:         quick_add.Text,
+		Title:         result.Task.Title,
+		Due:           result.Task.Due_date,
+		Due_local:     result.Due.Format(time.RFC3339),
+		Timezone:      loc.String(),
+		Due_defaulted: result.DueDefaulted,
+		Priority:      result.Task.Priority,
+		Category:      result.Task.Category,
+		Matches:       result.Matches,
+	}
+
+	err = validators.ValidateTask(h.DB, db_ctx, user_id, loc, result.Task)
+
+	if quick_add.Preview {
+		if err != nil {
+			quick_task.Error = err.Error()
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(quick_task)
+		return
+	}
+
+	if err != nil {
+		h.Logger.Error("validate: task validation error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = postgres.InsertTask(h.DB, db_ctx, user_id, result.Task)
+	if errors.Is(err, errWIPLimit) {
+		h.Logger.Warn("Task creation rejected", "err", err)
+		http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("postgres: insertion error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	quick_task.Created = true
+
+	h.Logger.Info("Task was created", "task", result.Task.Title)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(quick_task)
+}
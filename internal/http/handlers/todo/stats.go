@@ -0,0 +1,115 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/storage/postgres"
+	redis_ "todo/internal/storage/redis"
+)
+
+const dateLayout = "2006-01-02"
+
+const defaultStatsDays = 30
+
+const maxStatsDays = 366
+
+// GetStats returns completion statistics over the from-to range of dates,
+// the last 30 days by default. Results are cached for StatsTTL when it's set,
+// so they can lag behind task changes by that long.
+func (h *TasksHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer db_cancel()
+
+	loc, err := h.location(db_ctx, user_id, r.URL.Query().Get("tz"))
+	if err != nil {
+		h.Logger.Error("request: timezone error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	today := time.Now().In(loc).Format(dateLayout)
+
+	from, to, err := statsRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"), today)
+	if err != nil {
+		h.Logger.Error("request: stats range error", "err", err)
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Streaks depend on the current day, so it is part of the key
+	cache_key := fmt.Sprintf("%d:%s:%s:%s:%s", user_id, from, to, loc.String(), today)
+
+	if h.StatsTTL > 0 {
+		stats, err := redis_.GetStats(h.Cache, db_ctx, cache_key)
+		if err == nil {
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(stats)
+			return
+		}
+	}
+
+	stats, err := postgres.SelectStats(h.DB, db_ctx, user_id, from, to, loc.String(), today)
+	if err != nil {
+		h.Logger.Error("postgres: select stats error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if h.StatsTTL > 0 {
+		if err := redis_.StoreStats(h.Cache, db_ctx, cache_key, stats, h.StatsTTL); err != nil {
+			h.Logger.Warn("Redis failed to cache stats", "err", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
+
+func statsRange(from_param string, to_param string, today string) (string, string, error) {
+	to, err := time.Parse(dateLayout, today)
+	if err != nil {
+		return "", "", err
+	}
+
+	if to_param != "" {
+		to, err = time.Parse(dateLayout, to_param)
+		if err != nil {
+			return "", "", errors.New("to param should be YYYY-MM-DD")
+		}
+	}
+
+	from := to.AddDate(0, 0, 1-defaultStatsDays)
+
+	if from_param != "" {
+		from, err = time.Parse(dateLayout, from_param)
+		if err != nil {
+			return "", "", errors.New("from param should be YYYY-MM-DD")
+		}
+	}
+
+	if from.After(to) {
+		return "", "", errors.New("from must not be after to")
+	}
+
+	if to.Sub(from) >= maxStatsDays*24*time.Hour {
+		return "", "", fmt.Errorf("range can't exceed %d days", maxStatsDays)
+	}
+
+	return from.Format(dateLayout), to.Format(dateLayout), nil
+}
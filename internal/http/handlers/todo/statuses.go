@@ -0,0 +1,472 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/task"
+	"todo/internal/utils/validators"
+)
+
+var (
+	errUnknownStatus = errors.New("unknown status")
+	errTransition    = errors.New("status transition not allowed")
+	errWIPLimit      = postgres.ErrWIPLimit
+)
+
+func (h *TasksHandler) GetStatuses(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	statuses, err := h.selectStatuses(db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select statuses error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(statuses)
+}
+
+func (h *TasksHandler) PostStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var new_status models.NewStatus
+
+	err := json.NewDecoder(r.Body).Decode(&new_status)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	statuses, err := h.selectStatuses(db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select statuses error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	status := models.Status{
+		Name:      strings.TrimSpace(new_status.Name),
+		Done:      new_status.Done,
+		WIP_limit: wipLimit(new_status.WIP_limit),
+	}
+
+	err = validators.ValidateStatusName(status.Name)
+	if err == nil {
+		err = validators.ValidateWIPLimit(new_status.WIP_limit)
+	}
+	if err == nil {
+		status.Transition_IDs, err = transitionIDs(statuses, new_status.Transitions)
+	}
+	if err != nil {
+		h.Logger.Error("validate: status validation error", "err", err)
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := findStatus(statuses, status.Name); ok {
+		h.Logger.Warn("Status with provided name exists", "name", status.Name)
+		http.Error(w, "Status with provided name exists", http.StatusConflict)
+		return
+	}
+
+	status, err = postgres.InsertStatus(h.DB, db_ctx, user_id, status)
+	if err != nil {
+		h.Logger.Error("postgres: insert status error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	fillTransitions(append(statuses, status), &status)
+
+	h.Logger.Info("Status was created", "status", status.Name)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(status)
+}
+
+func (h *TasksHandler) PatchStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	var update_status models.UpdateStatus
+
+	err = json.NewDecoder(r.Body).Decode(&update_status)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	statuses, err := h.selectStatuses(db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select statuses error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	ind := slices.IndexFunc(statuses, func(status models.Status) bool { return status.ID == id })
+	if ind < 0 {
+		h.Logger.Warn("postgres: status was not found", "id", id)
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	status := &statuses[ind]
+
+	if update_status.Name != nil {
+		name := strings.TrimSpace(*update_status.Name)
+
+		if err := validators.ValidateStatusName(name); err != nil {
+			h.Logger.Error("validate: status validation error", "err", err)
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if other, ok := findStatus(statuses, name); ok && other.ID != id {
+			h.Logger.Warn("Status with provided name exists", "name", name)
+			http.Error(w, "Status with provided name exists", http.StatusConflict)
+			return
+		}
+
+		status.Name = name
+	}
+
+	if update_status.Done != nil {
+		status.Done = *update_status.Done
+	}
+
+	if update_status.WIP_limit != nil {
+		if err := validators.ValidateWIPLimit(update_status.WIP_limit); err != nil {
+			h.Logger.Error("validate: status validation error", "err", err)
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		status.WIP_limit = wipLimit(update_status.WIP_limit)
+	}
+
+	if update_status.Transitions != nil {
+		status.Transition_IDs, err = transitionIDs(statuses, *update_status.Transitions)
+		if err != nil {
+			h.Logger.Error("validate: status validation error", "err", err)
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = validators.ValidateStatuses(statuses)
+	if err != nil {
+		h.Logger.Error("validate: status validation error", "err", err)
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = postgres.UpdateStatus(h.DB, db_ctx, user_id, *status)
+	if err != nil {
+		h.Logger.Error("postgres: update status error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	fillTransitions(statuses, status)
+
+	h.Logger.Info("Status was updated", "status", status.Name)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
+
+// DeleteStatus removes a status. Its tasks move to the first open or done
+// status, depending on completed.
+func (h *TasksHandler) DeleteStatus(w http.ResponseWriter, r *http.Request) {
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	statuses, err := h.selectStatuses(db_ctx, user_id)
+	if err != nil {
+		h.Logger.Error("postgres: select statuses error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	remaining := slices.DeleteFunc(slices.Clone(statuses), func(status models.Status) bool { return status.ID == id })
+
+	if len(remaining) == len(statuses) {
+		h.Logger.Warn("postgres: status was not found", "id", id)
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	err = validators.ValidateStatuses(remaining)
+	if err != nil {
+		h.Logger.Error("validate: status validation error", "err", err)
+		http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+		return
+	}
+
+	rows_affected, err := postgres.RemoveStatus(h.DB, db_ctx, user_id, id)
+	if err != nil {
+		h.Logger.Error("postgres: delete status error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if rows_affected == 0 {
+		h.Logger.Warn("postgres: status was not found", "id", id)
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	h.Logger.Info("Status was deleted", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// OrderStatuses takes the ids of all the user's statuses in their new order.
+func (h *TasksHandler) OrderStatuses(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var order models.Order
+
+	err := json.NewDecoder(r.Body).Decode(&order)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	err = postgres.ReorderStatuses(h.DB, db_ctx, user_id, order.IDs)
+	if err == postgres.ErrOrder {
+		h.Logger.Error("request: status order error", "err", err)
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("postgres: reorder statuses error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("Statuses were reordered")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// updateTask stores an update of a task. An update that moves the task to
+// another status, by naming one or by changing completed alone, is checked
+// against the transitions of its current status and the WIP limit of the
+// new one in the transaction that moves it. A named status sets completed to
+// its done flag, a change of completed puts the task in the first open or
+// done status unless it already is in one.
+func (h *TasksHandler) updateTask(ctx context.Context, user_id int, task_uuid string, update_task models.UpdateTask) error {
+	if update_task.Status == nil && update_task.Completed == nil {
+		update_query, args := task_utils.GetUpdateQuery(user_id, task_uuid, update_task)
+		return postgres.UpdateTask(h.DB, ctx, update_query, args)
+	}
+
+	statuses, err := h.selectStatuses(ctx, user_id)
+	if err != nil {
+		return err
+	}
+
+	var target models.Status
+	var ok bool
+
+	if update_task.Status != nil {
+		target, ok = findStatus(statuses, strings.TrimSpace(*update_task.Status))
+		if !ok {
+			return errUnknownStatus
+		}
+
+		update_task.Status_ID = &target.ID
+		update_task.Completed = &target.Done
+	} else {
+		target, ok = firstStatus(statuses, *update_task.Completed)
+		if !ok {
+			return errUnknownStatus
+		}
+	}
+
+	update_query, args := task_utils.GetUpdateQuery(user_id, task_uuid, update_task)
+
+	return postgres.MoveTask(h.DB, ctx, user_id, task_uuid, target.ID, update_query, args, func(target models.Status, current string, count int) error {
+		if current == target.Name {
+			return nil
+		}
+
+		current_status, found := findStatus(statuses, current)
+
+		// Completing a task that already is in a done status, or reopening
+		// one in an open status, leaves it where it is
+		if update_task.Status == nil && found && current_status.Done == target.Done {
+			return nil
+		}
+
+		if found && len(current_status.Transition_IDs) > 0 && !slices.Contains(current_status.Transition_IDs, int64(target.ID)) {
+			return fmt.Errorf("%w: %s to %s", errTransition, current_status.Name, target.Name)
+		}
+
+		if target.WIP_limit != nil && count >= *target.WIP_limit {
+			return fmt.Errorf("%w: %s holds %d tasks", errWIPLimit, target.Name, count)
+		}
+
+		return nil
+	})
+}
+
+// selectStatuses returns the user's statuses, creating the defaults first.
+func (h *TasksHandler) selectStatuses(ctx context.Context, user_id int) ([]models.Status, error) {
+	err := postgres.EnsureStatuses(h.DB, ctx, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses, err := postgres.SelectStatuses(h.DB, ctx, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range statuses {
+		fillTransitions(statuses, &statuses[i])
+	}
+
+	return statuses, nil
+}
+
+// firstStatus is the status a task falls back to once completed or
+// reopened, as statusColumn resolves it.
+func firstStatus(statuses []models.Status, done bool) (models.Status, bool) {
+	for _, status := range statuses {
+		if status.Done == done {
+			return status, true
+		}
+	}
+
+	return models.Status{}, false
+}
+
+func findStatus(statuses []models.Status, name string) (models.Status, bool) {
+	for _, status := range statuses {
+		if status.Name == name {
+			return status, true
+		}
+	}
+
+	return models.Status{}, false
+}
+
+func fillTransitions(statuses []models.Status, status *models.Status) {
+	status.Transitions = []string{}
+
+	for _, id := range status.Transition_IDs {
+		for _, other := range statuses {
+			if int64(other.ID) == id {
+				status.Transitions = append(status.Transitions, other.Name)
+			}
+		}
+	}
+}
+
+func transitionIDs(statuses []models.Status, names []string) ([]int64, error) {
+	var ids []int64
+
+	for _, name := range names {
+		status, ok := findStatus(statuses, strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("%w %q in transitions", errUnknownStatus, name)
+		}
+
+		ids = append(ids, int64(status.ID))
+	}
+
+	return ids, nil
+}
+
+// A WIP limit of 0 means no limit.
+func wipLimit(wip_limit *int) *int {
+	if wip_limit == nil || *wip_limit == 0 {
+		return nil
+	}
+
+	return wip_limit
+}
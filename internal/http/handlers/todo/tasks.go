@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"time"
@@ -21,6 +22,8 @@ type TasksHandler struct {
 	DB *sql.DB
 	Cache *redis.Client
 	Logger *slog.Logger
+	// StatsTTL caches stats in redis for that long, zero disables the cache
+	StatsTTL time.Duration
 }
 
 
@@ -36,15 +39,22 @@ func (h *TasksHandler) GetTasks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query_params, args, err := task_utils.GetDynamicQuery(user_id, r)
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second * 3)
+	defer db_cancel()
+
+	loc, err := h.location(db_ctx, user_id, r.URL.Query().Get("tz"))
 	if err != nil {
-		h.Logger.Error("dynamic query error", "err", err)
+		h.Logger.Error("request: timezone error", "err", err)
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
 
-	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second * 3)
-	defer db_cancel()
+	query_params, args, err := task_utils.GetDynamicQuery(user_id, loc, r)
+	if err != nil {
+		h.Logger.Error("dynamic query error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
 
 	tasks, err := postgres.SelectTasks(h.DB, db_ctx, query_params, args)
 	if err != nil {
@@ -53,6 +63,10 @@ func (h *TasksHandler) GetTasks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	for i := range tasks {
+		task_utils.RenderTask(&tasks[i], loc)
+	}
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(tasks)
 }
@@ -81,7 +95,16 @@ func (h *TasksHandler) PostTask(w http.ResponseWriter, r *http.Request) {
 	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second * 3)
 	defer db_cancel()
 
-	err = validators.ValidateTask(h.DB, db_ctx, user_id, new_task)
+	loc, err := h.location(db_ctx, user_id, r.URL.Query().Get("tz"))
+	if err != nil {
+		h.Logger.Error("request: timezone error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	new_task.Due_date = task_utils.NormalizeDue(new_task.Due_date, loc)
+
+	err = validators.ValidateTask(h.DB, db_ctx, user_id, loc, new_task)
 	if err != nil {
 		h.Logger.Error("validate: task validation error", "err", err)
 		http.Error(w, "Bad request", http.StatusBadRequest)
@@ -89,6 +112,11 @@ func (h *TasksHandler) PostTask(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = postgres.InsertTask(h.DB, db_ctx, user_id, new_task)
+	if errors.Is(err, errWIPLimit) {
+		h.Logger.Warn("Task creation rejected", "err", err)
+		http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		h.Logger.Error("postgres: insertion error", "err", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
@@ -116,6 +144,13 @@ func (h *TasksHandler) GetTask(w http.ResponseWriter, r *http.Request) {
 	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second * 3)
 	defer db_cancel()
 
+	loc, err := h.location(db_ctx, user_id, r.URL.Query().Get("tz"))
+	if err != nil {
+		h.Logger.Error("request: timezone error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
 	task, err := postgres.SelectTask(h.DB, db_ctx, user_id, task_uuid)
 	if err != nil {
 		h.Logger.Warn("postgres: task was not found", "err", err)
@@ -123,6 +158,8 @@ func (h *TasksHandler) GetTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	task_utils.RenderTask(&task, loc)
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(task)
 }
@@ -144,23 +181,37 @@ func (h *TasksHandler) PatchTask(w http.ResponseWriter, r *http.Request) {
 	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second * 3)
 	defer db_cancel()
 
-	update_task, err := validators.GetValidateUpdateParams(h.DB, db_ctx, user_id, r)
+	loc, err := h.location(db_ctx, user_id, r.URL.Query().Get("tz"))
+	if err != nil {
+		h.Logger.Error("request: timezone error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	update_task, err := validators.GetValidateUpdateParams(h.DB, db_ctx, user_id, loc, r)
 	if err != nil {
 		h.Logger.Error("validate: update params validation failed", "err", err)
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
 
-	update_query, args := task_utils.GetUpdateQuery(user_id, task_uuid, update_task)
+	err = h.updateTask(db_ctx, user_id, task_uuid, update_task)
 
-	err = postgres.UpdateTask(h.DB, db_ctx, update_query, args)
-	if err != nil {
-		if err == sql.ErrNoRows {
+	switch {
+	case err == nil:
+	case errors.Is(err, sql.ErrNoRows):
 		h.Logger.Warn("postgres: task was not found", "err", err)
 		http.Error(w, "Not found", http.StatusNotFound)
 		return
-	}
-
+	case errors.Is(err, errUnknownStatus), errors.Is(err, postgres.ErrStatusNotFound):
+		h.Logger.Error("validate: unknown status", "err", err)
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, errTransition), errors.Is(err, errWIPLimit):
+		h.Logger.Warn("Task move rejected", "err", err)
+		http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+		return
+	default:
 		h.Logger.Error("postgres: update task error", "err", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
@@ -0,0 +1,394 @@
+package todo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/task"
+	"todo/internal/utils/validators"
+)
+
+const defaultTemplatePriority = "medium"
+
+func (h *TasksHandler) GetTemplates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	templates, err := postgres.SelectTemplates(h.DB, db_ctx, user_id, 0)
+	if err != nil {
+		h.Logger.Error("postgres: select templates error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(templates)
+}
+
+func (h *TasksHandler) PostTemplate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var new_template models.NewTemplate
+
+	err := json.NewDecoder(r.Body).Decode(&new_template)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	template := models.Template{Name: strings.TrimSpace(new_template.Name), Tasks: trimTemplateTasks(new_template.Tasks)}
+
+	err = validators.ValidateTemplate(template)
+	if err != nil {
+		h.Logger.Error("validate: template validation error", "err", err)
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	if postgres.TemplateExists(h.DB, db_ctx, user_id, template.Name) {
+		h.Logger.Warn("Template with provided name exists", "name", template.Name)
+		http.Error(w, "Template with provided name exists", http.StatusConflict)
+		return
+	}
+
+	id, err := postgres.InsertTemplate(h.DB, db_ctx, user_id, template)
+	if err == nil {
+		template, err = postgres.SelectTemplate(h.DB, db_ctx, user_id, id)
+	}
+	if err != nil {
+		h.Logger.Error("postgres: insert template error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("Template was created", "template", template.Name)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(template)
+}
+
+func (h *TasksHandler) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	template, ok := h.selectTemplate(w, r, db_ctx, user_id)
+	if !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(template)
+}
+
+func (h *TasksHandler) PatchTemplate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var update_template models.UpdateTemplate
+
+	err := json.NewDecoder(r.Body).Decode(&update_template)
+	if err != nil {
+		h.Logger.Error("request: parsing error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	template, ok := h.selectTemplate(w, r, db_ctx, user_id)
+	if !ok {
+		return
+	}
+
+	current_name := template.Name
+
+	if update_template.Name != nil {
+		template.Name = strings.TrimSpace(*update_template.Name)
+	}
+
+	if update_template.Tasks != nil {
+		template.Tasks = trimTemplateTasks(*update_template.Tasks)
+	}
+
+	err = validators.ValidateTemplate(template)
+	if err != nil {
+		h.Logger.Error("validate: template validation error", "err", err)
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if template.Name != current_name && postgres.TemplateExists(h.DB, db_ctx, user_id, template.Name) {
+		h.Logger.Warn("Template with provided name exists", "name", template.Name)
+		http.Error(w, "Template with provided name exists", http.StatusConflict)
+		return
+	}
+
+	err = postgres.UpdateTemplate(h.DB, db_ctx, user_id, template)
+	if err == nil {
+		template, err = postgres.SelectTemplate(h.DB, db_ctx, user_id, template.ID)
+	}
+	if err != nil {
+		h.Logger.Error("postgres: update template error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("Template was updated", "template", template.Name)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(template)
+}
+
+func (h *TasksHandler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	rows_affected, err := postgres.RemoveTemplate(h.DB, db_ctx, user_id, id)
+	if err != nil {
+		h.Logger.Error("postgres: delete template error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if rows_affected == 0 {
+		h.Logger.Warn("postgres: template was not found", "id", id)
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	h.Logger.Info("Template was deleted", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// InstantiateTemplate creates the tasks of a template, all or none, with due
+// dates offset from the anchor param. The anchor is a date or a date and time
+// read in the user's timezone, and defaults to now. A title already taken
+// gets the first free " (n)" suffix.
+func (h *TasksHandler) InstantiateTemplate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*10)
+	defer db_cancel()
+
+	loc, err := h.location(db_ctx, user_id, r.URL.Query().Get("tz"))
+	if err != nil {
+		h.Logger.Error("request: timezone error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	anchor, date_only, err := parseAnchor(r.URL.Query().Get("anchor"), loc)
+	if err != nil {
+		h.Logger.Error("request: anchor param error", "err", err)
+		http.Error(w, "Bad request: anchor should be YYYY-MM-DD, RFC 3339 or YYYY-MM-DD HH:MM:SS", http.StatusBadRequest)
+		return
+	}
+
+	template, ok := h.selectTemplate(w, r, db_ctx, user_id)
+	if !ok {
+		return
+	}
+
+	var prefixes []string
+
+	for _, template_task := range template.Tasks {
+		prefixes = append(prefixes, template_task.Title)
+	}
+
+	taken, err := postgres.SelectTitlesLike(h.DB, db_ctx, user_id, prefixes)
+	if err != nil {
+		h.Logger.Error("postgres: select titles error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	rows := []models.ImportTask{}
+	created := []models.NewTask{}
+
+	for ind, template_task := range template.Tasks {
+		offset, err := task_utils.ParseDueOffset(template_task.Due_offset)
+		if err != nil {
+			h.Logger.Error("validate: template due offset error", "err", err)
+			http.Error(w, fmt.Sprintf("Bad request: template task %d: %s", ind+1, err), http.StatusBadRequest)
+			return
+		}
+
+		new_task := models.NewTask{
+			Title:    freeTitle(template_task.Title, taken),
+			Due_date: offset.Apply(anchor, date_only).UTC().Format(time.RFC3339),
+			Priority: template_task.Priority,
+			Category: template_task.Category,
+		}
+		taken[new_task.Title] = true
+
+		err = validators.ValidateTask(h.DB, db_ctx, user_id, loc, new_task)
+		if err != nil {
+			h.Logger.Error("validate: template task validation error", "err", err)
+			http.Error(w, fmt.Sprintf("Bad request: template task %d: %s", ind+1, err), http.StatusBadRequest)
+			return
+		}
+
+		rows = append(rows, models.ImportTask{Line: ind + 1, Task: new_task})
+		created = append(created, new_task)
+	}
+
+	err = postgres.InsertImportedTasks(h.DB, db_ctx, user_id, rows)
+	if errors.Is(err, errWIPLimit) {
+		h.Logger.Warn("Task creation rejected", "err", err)
+		http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("postgres: instantiate template error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Info("Template was instantiated", "template", template.Name, "tasks", len(created))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// selectTemplate loads the template of the id path value, or writes the
+// error response.
+func (h *TasksHandler) selectTemplate(w http.ResponseWriter, r *http.Request, ctx context.Context, user_id int) (models.Template, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return models.Template{}, false
+	}
+
+	template, err := postgres.SelectTemplate(h.DB, ctx, user_id, id)
+	if err == sql.ErrNoRows {
+		h.Logger.Warn("postgres: template was not found", "id", id)
+		http.Error(w, "Not found", http.StatusNotFound)
+		return template, false
+	}
+	if err != nil {
+		h.Logger.Error("postgres: select template error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return template, false
+	}
+
+	return template, true
+}
+
+func trimTemplateTasks(tasks []models.TemplateTask) []models.TemplateTask {
+	for i := range tasks {
+		tasks[i].Title = strings.TrimSpace(tasks[i].Title)
+		tasks[i].Category = strings.TrimSpace(tasks[i].Category)
+		tasks[i].Priority = strings.ToLower(strings.TrimSpace(tasks[i].Priority))
+		tasks[i].Due_offset = strings.TrimSpace(tasks[i].Due_offset)
+
+		if tasks[i].Priority == "" {
+			tasks[i].Priority = defaultTemplatePriority
+		}
+	}
+
+	return tasks
+}
+
+func parseAnchor(param string, loc *time.Location) (time.Time, bool, error) {
+	param = strings.TrimSpace(param)
+
+	if param == "" {
+		return time.Now().In(loc), false, nil
+	}
+
+	if date, err := time.ParseInLocation("2006-01-02", param, loc); err == nil {
+		return date, true, nil
+	}
+
+	date, err := task_utils.ParseDue(param, loc)
+
+	return date.In(loc), false, err
+}
+
+func freeTitle(title string, taken map[string]bool) string {
+	candidate := title
+
+	for n := 2; taken[candidate]; n++ {
+		candidate = fmt.Sprintf("%s (%d)", title, n)
+	}
+
+	return candidate
+}
@@ -0,0 +1,23 @@
+package todo
+
+import (
+	"context"
+	"time"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/validators"
+)
+
+// location resolves the timezone a request is read and rendered in: the
+// requested one when set, otherwise the user's preference.
+func (h *TasksHandler) location(ctx context.Context, user_id int, requested string) (*time.Location, error) {
+	if requested != "" {
+		return validators.ValidateTimezone(requested)
+	}
+
+	timezone, err := postgres.GetUserTimezone(h.DB, ctx, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	return time.LoadLocation(timezone)
+}
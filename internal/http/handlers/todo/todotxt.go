@@ -0,0 +1,80 @@
+package todo
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/models"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/task"
+	"todo/internal/utils/todotxt"
+)
+
+func (h *TasksHandler) ExportTasksTodoTxt(w http.ResponseWriter, r *http.Request) {
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*30)
+	defer db_cancel()
+
+	loc, err := h.location(db_ctx, user_id, r.URL.Query().Get("tz"))
+	if err != nil {
+		h.Logger.Error("request: timezone error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	query_params, args, err := task_utils.GetDynamicQuery(user_id, loc, r)
+	if err != nil {
+		h.Logger.Error("dynamic query error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="todo.txt"`)
+
+	writer := bufio.NewWriter(w)
+	written := 0
+
+	err = postgres.StreamTodoTxtTasks(h.DB, db_ctx, query_params, args, func(task models.Task, extras string, completed_at string) error {
+		written++
+		_, err := writer.WriteString(todotxt_utils.FormatTask(task, extras, completed_at, loc) + "\n")
+		return err
+	})
+
+	writer.Flush()
+
+	if err != nil {
+		h.Logger.Error("postgres: export tasks error", "err", err, "rows", written)
+		return
+	}
+
+	h.Logger.Info("Tasks were exported", "rows", written)
+}
+
+func (h *TasksHandler) ImportTasksTodoTxt(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h.importTasks(w, r, user_id, func(body io.Reader) ([]models.ImportTask, error) {
+		return todotxt_utils.ReadTasks(body)
+	})
+}
@@ -0,0 +1,134 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/storage/postgres"
+	"todo/internal/utils/task"
+)
+
+// GetViewTasks lists the tasks of a built-in view. The filters, sort and
+// limit of GetTasks apply on top of the view.
+func (h *TasksHandler) GetViewTasks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	days, err := task_utils.GetViewDays(r)
+	if err != nil {
+		h.Logger.Error("request: days param error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	loc, err := h.location(db_ctx, user_id, r.URL.Query().Get("tz"))
+	if err != nil {
+		h.Logger.Error("request: timezone error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	view, err := task_utils.GetView(r.PathValue("view"), time.Now().In(loc), days)
+	if err != nil {
+		h.Logger.Warn("request: unknown view", "view", r.PathValue("view"))
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	query_params, args, err := task_utils.GetFilteredQuery(user_id, loc, r, view.Conditions, view.Sort)
+	if err != nil {
+		h.Logger.Error("dynamic query error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	tasks, err := postgres.SelectTasks(h.DB, db_ctx, query_params, args)
+	if err != nil {
+		h.Logger.Error("postgres: select view tasks error", "view", view.Name, "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range tasks {
+		task_utils.RenderTask(&tasks[i], loc)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tasks)
+}
+
+// GetViewCounts returns the number of tasks in every view, for badges.
+func (h *TasksHandler) GetViewCounts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	val := r.Context().Value(ctx.UserIDKey)
+
+	user_id, ok := val.(int)
+	if !ok {
+		h.Logger.Error("request: failed to get context key value")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	days, err := task_utils.GetViewDays(r)
+	if err != nil {
+		h.Logger.Error("request: days param error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	loc, err := h.location(db_ctx, user_id, r.URL.Query().Get("tz"))
+	if err != nil {
+		h.Logger.Error("request: timezone error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().In(loc)
+
+	var views []task_utils.View
+
+	for _, name := range task_utils.ViewNames {
+		view, _ := task_utils.GetView(name, now, days)
+		views = append(views, view)
+	}
+
+	query, args, err := task_utils.GetViewCountQuery(user_id, loc, r, views)
+	if err != nil {
+		h.Logger.Error("dynamic query error", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	counts, err := postgres.SelectCounts(h.DB, db_ctx, query, args, len(views))
+	if err != nil {
+		h.Logger.Error("postgres: count view tasks error", "err", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	view_counts := map[string]int{}
+
+	for i, view := range views {
+		view_counts[view.Name] = counts[i]
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(view_counts)
+}
@@ -0,0 +1,140 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"todo/internal/config"
+	"todo/internal/utils/session"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New picks the mailer named by MAIL_DRIVER. Without one, mails are only
+// logged, which is enough for development, see LogMailer.
+func New(cfg config.Config, logger *slog.Logger) Mailer {
+	switch cfg.MailDriver {
+	case "smtp":
+		return &SMTPMailer{Addr: cfg.SMTPAddr, Username: cfg.SMTPUser, Password: cfg.SMTPPassword, From: cfg.MailFrom}
+	case "file":
+		return &FileMailer{Dir: cfg.MailDir, From: cfg.MailFrom}
+	default:
+		logger.Warn("mail: no MAIL_DRIVER set, mails are logged and their bodies only at debug level")
+		return &LogMailer{Logger: logger}
+	}
+}
+
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, format(m.From, msg))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FileMailer drops each mail as an .eml file, for tests and staging.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0700); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), session.MustGenerateUUID())
+
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0600)
+}
+
+// SendBackground sends without waiting, so that neither a response nor its
+// timing depends on the mail server. Failures are only logged.
+func SendBackground(m Mailer, logger *slog.Logger, msg Message) {
+	go func() {
+		mail_ctx, mail_cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer mail_cancel()
+
+		err := m.Send(mail_ctx, msg)
+		if err != nil {
+			logger.Error("mail: send error", "to", msg.To, "subject", msg.Subject, "err", err)
+		}
+	}()
+}
+
+// Link appends a secret to the page a mail points to. Without a page the
+// secret is sent as is.
+func Link(base string, secret string) string {
+	if base == "" {
+		return secret
+	}
+
+	return base + "?token=" + url.QueryEscape(secret)
+}
+
+// LogMailer logs mails instead of sending them. Bodies carry reset, verify
+// and email change links, which give over the account to whoever reads them,
+// so they are only logged at debug level, for LOG_LEVEL=debug in development.
+type LogMailer struct {
+	Logger *slog.Logger
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	m.Logger.Info("mail", "to", msg.To, "subject", msg.Subject)
+	m.Logger.Debug("mail body", "to", msg.To, "body", msg.Body)
+	return nil
+}
+
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return []byte(b.String())
+}
@@ -2,12 +2,17 @@ package middleware
 
 import (
 	"context"
+	"database/sql"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 	"todo/internal/http/context"
+	"todo/internal/storage/postgres"
 	redis_ "todo/internal/storage/redis"
 	"todo/internal/utils/session"
+	"todo/internal/utils/token"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -23,13 +28,63 @@ func (w *wrappedWriter) WriteHeader(statusCode int) {
 }
 
 var PublicRoutes = map[string]string{
-	"/health":   "/health",
-	"/register": "/register",
-	"/login":    "/login",
+	"/health":             "/health",
+	"/register":           "/register",
+	"/login":              "/login",
+	"/login/2fa":          "/login/2fa",
+	"/oidc/login":         "/oidc/login",
+	"/oidc/callback":      "/oidc/callback",
+	"/password/forgot":    "/password/forgot",
+	"/password/reset":     "/password/reset",
+	"/email/verify":       "/email/verify",
+	"/email/confirm":      "/email/confirm",
+	"/calendar.ics":       "/calendar.ics",
+	"/.well-known/caldav": "/.well-known/caldav",
+}
+
+// Routes under these prefixes authenticate requests themselves
+var PublicPrefixes = []string{
+	"/dav/",
+	"/admin/",
 }
 
 const renewThreshold = 15 * 60
 
+// How often, in seconds, the last seen time of a session is written
+const lastSeenInterval = 60
+
+// What a user whose email isn't verified may do
+const (
+	PolicyFull      = "full"
+	PolicyReadOnly  = "read-only"
+	PolicyLoginOnly = "login-only"
+)
+
+var Policies = []string{PolicyFull, PolicyReadOnly, PolicyLoginOnly}
+
+// Routes an unverified user may always use, whatever the policy
+var UnverifiedRoutes = map[string]string{
+	"/logout":              "/logout",
+	"/me":                  "/me",
+	"/email/verify/resend": "/email/verify/resend",
+	"/csrf":                "/csrf",
+}
+
+func allowedUnverified(r *http.Request, policy string) bool {
+	if _, ok := UnverifiedRoutes[r.URL.Path]; ok {
+		return true
+	}
+
+	switch policy {
+	case PolicyReadOnly:
+		return safeMethod(r)
+	case PolicyLoginOnly:
+		return false
+	default:
+		return true
+	}
+}
+
 func LoggingMiddleWare(next http.Handler, logger *slog.Logger) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		req_start := time.Now()
@@ -46,13 +101,116 @@ func LoggingMiddleWare(next http.Handler, logger *slog.Logger) http.Handler {
 	})
 }
 
-func AuthMiddleWare(next http.Handler, logger *slog.Logger, cache *redis.Client) http.Handler {
+// Resources behind the first path segment, for the scope of access tokens
+var scopeResources = map[string]string{
+	"tasks":     "tasks",
+	"board":     "tasks",
+	"statuses":  "tasks",
+	"templates": "tasks",
+	"stats":     "tasks",
+	"filters":   "tasks",
+	"me":        "account",
+}
+
+// RequiredScope is the scope an access token needs for the request, read for
+// safe methods and write otherwise. Routes without one are not open to
+// access tokens.
+func RequiredScope(r *http.Request) (string, bool) {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	resource, ok := scopeResources[segment]
+	if !ok {
+		return "", false
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return resource + ":read", true
+	}
+
+	return resource + ":write", true
+}
+
+// bearerAuth authenticates a request carrying an access token, returning its
+// user and token hash, or writes the error response.
+func bearerAuth(w http.ResponseWriter, r *http.Request, logger *slog.Logger, DB *sql.DB, unverified_policy string, authorization string) (int, string, bool) {
+	secret, found := strings.CutPrefix(authorization, "Bearer ")
+	if !found || secret == "" {
+		logger.Warn("Unsupported authorization scheme")
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_request"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return 0, "", false
+	}
+
+	db_ctx, db_cancel := context.WithTimeout(r.Context(), time.Second*3)
+	defer db_cancel()
+
+	token_hash := token.Hash(strings.TrimSpace(secret))
+
+	user_id, scopes, verified, err := postgres.AuthenticateAccessToken(DB, db_ctx, token_hash)
+	if err == sql.ErrNoRows {
+		logger.Warn("Invalid or expired access token")
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return 0, "", false
+	}
+	if err != nil {
+		logger.Error("postgres: authenticate access token error", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return 0, "", false
+	}
+
+	scope, ok := RequiredScope(r)
+	if !ok {
+		logger.Warn("Route not open to access tokens", "user", user_id, "path", r.URL.Path)
+		http.Error(w, "Not available to access tokens", http.StatusForbidden)
+		return 0, "", false
+	}
+
+	if !slices.Contains(scopes, scope) {
+		logger.Warn("Access token scope missing", "user", user_id, "scope", scope)
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+		http.Error(w, "Insufficient scope", http.StatusForbidden)
+		return 0, "", false
+	}
+
+	if !verified && !allowedUnverified(r, unverified_policy) {
+		logger.Warn("Email not verified", "user", user_id, "policy", unverified_policy)
+		http.Error(w, "Email not verified", http.StatusForbidden)
+		return 0, "", false
+	}
+
+	return user_id, token_hash, true
+}
+
+// AuthMiddleWare accepts either a session cookie or an access token in an
+// Authorization: Bearer header.
+func AuthMiddleWare(next http.Handler, logger *slog.Logger, cache *redis.Client, DB *sql.DB, unverified_policy string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if _, ok := PublicRoutes[r.URL.Path]; ok {
 			next.ServeHTTP(w, r)
 			return
 		}
 
+		for _, prefix := range PublicPrefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if authorization := r.Header.Get("Authorization"); authorization != "" {
+			user_id, token_hash, ok := bearerAuth(w, r, logger, DB, unverified_policy, authorization)
+			if !ok {
+				return
+			}
+
+			token_ctx := context.WithValue(r.Context(), ctx.TokenIDKey, token_hash)
+			ctx := context.WithValue(token_ctx, ctx.UserIDKey, user_id)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		session_cookie, err := r.Cookie("session_id")
 
 		if err != nil || session_cookie.Value == "" {
@@ -74,7 +232,7 @@ func AuthMiddleWare(next http.Handler, logger *slog.Logger, cache *redis.Client)
 
 		if session_s.EXP-time.Now().Unix() < renewThreshold {
 
-			err = redis_.RenewSession(cache, redis_ctx, session_cookie.Value)
+			err = redis_.RenewSession(cache, redis_ctx, session_cookie.Value, session_s)
 			if err != nil {
 				logger.Error("Redis failed to renew session", "err", err)
 				w.WriteHeader(http.StatusInternalServerError)
@@ -82,9 +240,27 @@ func AuthMiddleWare(next http.Handler, logger *slog.Logger, cache *redis.Client)
 			}
 
 			session.SetSessionCookie(w, session_cookie.Value)
+		} else if time.Now().Unix()-session_s.Last_seen >= lastSeenInterval {
+			err = redis_.TouchSession(cache, redis_ctx, session_cookie.Value, session_s)
+			if err != nil {
+				logger.Warn("Redis failed to touch session", "err", err)
+			}
+		}
+
+		if !session_s.Verified && !allowedUnverified(r, unverified_policy) {
+			logger.Warn("Email not verified", "user", session_s.UID, "policy", unverified_policy)
+			http.Error(w, "Email not verified", http.StatusForbidden)
+			return
+		}
+
+		if !safeMethod(r) && !validCSRFToken(r, session_s) {
+			logger.Warn("CSRF token missing or invalid", "user", session_s.UID, "path", r.URL.Path)
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
 		}
 
-		ctx := context.WithValue(r.Context(), ctx.UserIDKey, session_s.UID)
+		login_ctx := context.WithValue(r.Context(), ctx.LoginAtKey, session_s.IAT)
+		ctx := context.WithValue(login_ctx, ctx.UserIDKey, session_s.UID)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"todo/internal/http/context"
+	redis_ "todo/internal/storage/redis"
+	"todo/internal/utils/session"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitPolicy allows Limit requests per Window, in bursts of up to Limit.
+// Rules with the same Name share a bucket. A Limit of 0 means no limit.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// RateLimitRule applies a policy to a method, any when empty, and a path,
+// matched as a prefix when it ends with a slash.
+type RateLimitRule struct {
+	Method string
+	Path   string
+	Policy RateLimitPolicy
+}
+
+var (
+	loginPolicy  = RateLimitPolicy{Name: "login", Limit: 20, Window: time.Minute}
+	importPolicy = RateLimitPolicy{Name: "import", Limit: 10, Window: time.Minute}
+	exportPolicy = RateLimitPolicy{Name: "export", Limit: 10, Window: time.Minute}
+)
+
+// RateLimitRules are checked in order, the first match applies
+var RateLimitRules = []RateLimitRule{
+	{Method: http.MethodGet, Path: "/health", Policy: RateLimitPolicy{}},
+	{Method: http.MethodPost, Path: "/register", Policy: RateLimitPolicy{Name: "register", Limit: 5, Window: time.Hour}},
+	{Method: http.MethodPost, Path: "/login", Policy: loginPolicy},
+	{Method: http.MethodPost, Path: "/login/2fa", Policy: loginPolicy},
+	{Method: http.MethodGet, Path: "/oidc/login", Policy: loginPolicy},
+	{Method: http.MethodGet, Path: "/oidc/callback", Policy: loginPolicy},
+	{Method: http.MethodPost, Path: "/password/forgot", Policy: RateLimitPolicy{Name: "password-forgot", Limit: 5, Window: time.Hour}},
+	{Method: http.MethodPost, Path: "/password/reset", Policy: RateLimitPolicy{Name: "password-reset", Limit: 10, Window: time.Hour}},
+	{Method: http.MethodPost, Path: "/email/verify", Policy: RateLimitPolicy{Name: "email-verify", Limit: 10, Window: time.Hour}},
+	{Method: http.MethodPost, Path: "/tasks/import", Policy: importPolicy},
+	{Method: http.MethodPost, Path: "/tasks/import.txt", Policy: importPolicy},
+	{Method: http.MethodGet, Path: "/tasks/export.csv", Policy: exportPolicy},
+	{Method: http.MethodGet, Path: "/tasks/export.txt", Policy: exportPolicy},
+	{Method: http.MethodGet, Path: "/me/export", Policy: exportPolicy},
+	{Path: "/admin/", Policy: RateLimitPolicy{Name: "admin", Limit: 30, Window: time.Minute}},
+	// Sync clients send bursts of requests
+	{Path: "/dav/", Policy: RateLimitPolicy{Name: "dav", Limit: 600, Window: time.Minute}},
+}
+
+// Policies of the requests no rule matches, by whether they are
+// authenticated
+var (
+	DefaultRateLimit = RateLimitPolicy{Name: "default", Limit: 300, Window: time.Minute}
+	PublicRateLimit  = RateLimitPolicy{Name: "public", Limit: 60, Window: time.Minute}
+)
+
+// IPRateLimit is the policy every request counts against by IP before it
+// is authenticated, so that requests with missing or wrong credentials are
+// limited too. It leaves room for a whole office behind one IP.
+var IPRateLimit = RateLimitPolicy{Name: "ip", Limit: 1200, Window: time.Minute}
+
+func rateLimitPolicy(r *http.Request, authenticated bool) RateLimitPolicy {
+	for _, rule := range RateLimitRules {
+		if rule.Method != "" && rule.Method != r.Method {
+			continue
+		}
+
+		if r.URL.Path == rule.Path || (strings.HasSuffix(rule.Path, "/") && strings.HasPrefix(r.URL.Path, rule.Path)) {
+			return rule.Policy
+		}
+	}
+
+	if authenticated {
+		return DefaultRateLimit
+	}
+
+	return PublicRateLimit
+}
+
+// rateLimitKey is who the request is counted against: the access token, the
+// user, or the IP for requests that aren't authenticated yet.
+func rateLimitKey(r *http.Request) (string, bool) {
+	if token_hash, ok := r.Context().Value(ctx.TokenIDKey).(string); ok {
+		return "token:" + token_hash, true
+	}
+
+	if user_id, ok := r.Context().Value(ctx.UserIDKey).(int); ok {
+		return "user:" + strconv.Itoa(user_id), true
+	}
+
+	return "ip:" + session.GetIP(r), false
+}
+
+// RateLimitMiddleWare goes inside AuthMiddleWare, which sets the user and
+// token it counts by. The limits are kept in redis, so they hold across
+// instances. Requests go through when redis can't be reached.
+func RateLimitMiddleWare(next http.Handler, logger *slog.Logger, cache *redis.Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, authenticated := rateLimitKey(r)
+
+		if takeToken(w, r, logger, cache, rateLimitPolicy(r, authenticated), key) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// IPRateLimitMiddleWare goes outside AuthMiddleWare, which answers failing
+// credentials before RateLimitMiddleWare is reached, and counts every request
+// by IP under IPRateLimit. Routes without a limit are left out.
+func IPRateLimitMiddleWare(next http.Handler, logger *slog.Logger, cache *redis.Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := IPRateLimit
+		if rateLimitPolicy(r, false).Limit == 0 {
+			policy = RateLimitPolicy{}
+		}
+
+		if takeToken(w, r, logger, cache, policy, "ip:"+session.GetIP(r)) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// takeToken counts the request against the key under the policy, and
+// answers it with a 429 when the limit is reached. It reports whether the
+// request may go on.
+func takeToken(w http.ResponseWriter, r *http.Request, logger *slog.Logger, cache *redis.Client, policy RateLimitPolicy, key string) bool {
+	if policy.Limit == 0 {
+		return true
+	}
+
+	redis_ctx, redis_cancel := context.WithTimeout(r.Context(), time.Second)
+	defer redis_cancel()
+
+	limit, err := redis_.TakeToken(cache, redis_ctx, policy.Name+":"+key, policy.Limit, policy.Window)
+	if err != nil {
+		logger.Error("Redis failed to check rate limit", "err", err)
+		return true
+	}
+
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Window.Seconds())))
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(limit.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(limit.Reset)))
+
+	if !limit.Allowed {
+		logger.Warn("Rate limit exceeded", "key", key, "policy", policy.Name)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds(limit.Retry_after)))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return false
+	}
+
+	return true
+}
+
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Task struct {
 	Title      string `json:"title"`
 	Completed  bool   `json:"completed"`
@@ -8,6 +10,7 @@ type Task struct {
 	Updated_at string `json:"updated_at"`
 	Priority   string `json:"priority"`
 	Category   string `json:"category"`
+	Status     string `json:"status"`
 }
 
 type NewTask struct {
@@ -23,6 +26,227 @@ type UpdateTask struct {
 	Priority  *string `json:"priority"`
 	Category  *string `json:"category"`
 	Completed *bool   `json:"completed"`
+	Status    *string `json:"status"`
+	// Status_ID is the resolved Status, set by the handler
+	Status_ID *int `json:"-"`
+}
+
+// MoveTask places a task right before or right after another one.
+type MoveTask struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+type QuickAdd struct {
+	Text     string `json:"text"`
+	Timezone string `json:"timezone"`
+	Preview  bool   `json:"preview"`
+}
+
+type QuickMatch struct {
+	Text string `json:"text"`
+	Kind string `json:"kind"`
+}
+
+// QuickTask is the interpretation of a quick-add text, returned both for
+// previews and for created tasks.
+type QuickTask struct {
+	Input         string       `json:"input"`
+	Title         string       `json:"title"`
+	Due           string       `json:"due"`
+	Due_local     string       `json:"due_local"`
+	Timezone      string       `json:"timezone"`
+	Due_defaulted bool         `json:"due_defaulted"`
+	Priority      string       `json:"priority"`
+	Category      string       `json:"category"`
+	Matches       []QuickMatch `json:"matches"`
+	Created       bool         `json:"created"`
+	Error         string       `json:"error,omitempty"`
+}
+
+// ImportTask is a task parsed from an import file, along with the fields a
+// NewTask can't carry.
+type ImportTask struct {
+	Line      int
+	Task      NewTask
+	Completed bool
+	Extras    string
+}
+
+type ImportRowReport struct {
+	Line   int    `json:"line"`
+	Title  string `json:"title"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun   bool              `json:"dry_run"`
+	Mode     string            `json:"mode"`
+	Total    int               `json:"total"`
+	Valid    int               `json:"valid"`
+	Failed   int               `json:"failed"`
+	Imported int               `json:"imported"`
+	Rows     []ImportRowReport `json:"rows"`
+}
+
+type FeedToken struct {
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+type AppPassword struct {
+	ID           int     `json:"id"`
+	Name         string  `json:"name"`
+	Password     string  `json:"password,omitempty"`
+	Created_at   string  `json:"created_at"`
+	Last_used_at *string `json:"last_used_at"`
+}
+
+// AccessToken is a personal access token, sent as a Bearer token. Token is
+// only set in the response creating it.
+type AccessToken struct {
+	ID           int      `json:"id"`
+	Name         string   `json:"name"`
+	Scopes       []string `json:"scopes"`
+	Token        string   `json:"token,omitempty"`
+	Created_at   string   `json:"created_at"`
+	Expires_at   *string  `json:"expires_at"`
+	Last_used_at *string  `json:"last_used_at"`
+}
+
+// NewAccessToken never expires without Expires_in_days.
+type NewAccessToken struct {
+	Name            string   `json:"name"`
+	Scopes          []string `json:"scopes"`
+	Expires_in_days *int     `json:"expires_in_days"`
+}
+
+// Filter is a saved GET /tasks query. Status is "broken" when its params are
+// no longer accepted, with the reason in Error.
+type Filter struct {
+	ID         int               `json:"id"`
+	Name       string            `json:"name"`
+	Query      string            `json:"-"`
+	Params     map[string]string `json:"params"`
+	Position   int               `json:"position"`
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	Created_at string            `json:"created_at"`
+	Updated_at string            `json:"updated_at"`
+}
+
+type NewFilter struct {
+	Name   string            `json:"name"`
+	Params map[string]string `json:"params"`
+}
+
+type UpdateFilter struct {
+	Name   *string           `json:"name"`
+	Params map[string]string `json:"params"`
+}
+
+type Order struct {
+	IDs []int `json:"ids"`
+}
+
+type DateCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+type Breakdown struct {
+	Key       string `json:"key"`
+	Total     int    `json:"total"`
+	Completed int    `json:"completed"`
+	Overdue   int    `json:"overdue"`
+}
+
+type Streaks struct {
+	Current int `json:"current"`
+	Longest int `json:"longest"`
+}
+
+// Stats covers the From-To range in Timezone, except for the breakdowns and
+// streaks which cover every task.
+type Stats struct {
+	From                 string      `json:"from"`
+	To                   string      `json:"to"`
+	Timezone             string      `json:"timezone"`
+	Created              int         `json:"created"`
+	Completed            int         `json:"completed"`
+	Avg_completion_hours float64     `json:"avg_completion_hours"`
+	Due                  int         `json:"due"`
+	Overdue              int         `json:"overdue"`
+	Overdue_rate         float64     `json:"overdue_rate"`
+	Completed_per_day    []DateCount `json:"completed_per_day"`
+	Completed_per_week   []DateCount `json:"completed_per_week"`
+	Burndown             []DateCount `json:"burndown"`
+	By_category          []Breakdown `json:"by_category"`
+	By_priority          []Breakdown `json:"by_priority"`
+	Streaks              Streaks     `json:"streaks"`
+}
+
+// Status is a board column. Transitions lists the statuses a task may move
+// to from this one, any status when empty.
+type Status struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Position    int      `json:"position"`
+	Done        bool     `json:"done"`
+	WIP_limit   *int     `json:"wip_limit"`
+	Transitions []string `json:"transitions"`
+	// Transition_IDs is Transitions as stored
+	Transition_IDs []int64 `json:"-"`
+}
+
+type NewStatus struct {
+	Name        string   `json:"name"`
+	Done        bool     `json:"done"`
+	WIP_limit   *int     `json:"wip_limit"`
+	Transitions []string `json:"transitions"`
+}
+
+// UpdateStatus clears the WIP limit with a wip_limit of 0 and the
+// transitions with an empty list.
+type UpdateStatus struct {
+	Name        *string   `json:"name"`
+	Done        *bool     `json:"done"`
+	WIP_limit   *int      `json:"wip_limit"`
+	Transitions *[]string `json:"transitions"`
+}
+
+type BoardColumn struct {
+	Status     Status `json:"status"`
+	Count      int    `json:"count"`
+	Over_limit bool   `json:"over_limit"`
+	Tasks      []Task `json:"tasks"`
+}
+
+type TemplateTask struct {
+	Title      string `json:"title"`
+	Category   string `json:"category"`
+	Priority   string `json:"priority"`
+	Due_offset string `json:"due_offset"`
+}
+
+type Template struct {
+	ID         int            `json:"id"`
+	Name       string         `json:"name"`
+	Tasks      []TemplateTask `json:"tasks"`
+	Created_at string         `json:"created_at"`
+	Updated_at string         `json:"updated_at"`
+}
+
+type NewTemplate struct {
+	Name  string         `json:"name"`
+	Tasks []TemplateTask `json:"tasks"`
+}
+
+// UpdateTemplate replaces all the tasks of a template when Tasks is set.
+type UpdateTemplate struct {
+	Name  *string         `json:"name"`
+	Tasks *[]TemplateTask `json:"tasks"`
 }
 
 type User struct {
@@ -30,6 +254,93 @@ type User struct {
 	Password string `json:"password"`
 }
 
+type ForgotPassword struct {
+	Email string `json:"email"`
+}
+
+type ResetPassword struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// UnlockLogin names the account, the IP, or both, to unlock.
+type UnlockLogin struct {
+	Email string `json:"email"`
+	IP    string `json:"ip"`
+}
+
+type VerifyEmail struct {
+	Token string `json:"token"`
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TOTPCode struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+type DisableTOTP struct {
+	Password string `json:"password"`
+}
+
+// LoginChallenge is the answer to a correct password when two-factor is on.
+// Token identifies the pending login at POST /login/2fa.
+type LoginChallenge struct {
+	Two_factor bool   `json:"two_factor"`
+	Token      string `json:"token"`
+	Expires_in int    `json:"expires_in"`
+}
+
+// LoginTOTP completes a pending login with either a code or a recovery code.
+type LoginTOTP struct {
+	Token         string `json:"token"`
+	Code          string `json:"code"`
+	Recovery_code string `json:"recovery_code"`
+}
+
+type Profile struct {
+	ID                int     `json:"id"`
+	Email             string  `json:"email"`
+	Email_verified_at *string `json:"email_verified_at"`
+	Two_factor        bool    `json:"two_factor"`
+	Timezone          string  `json:"timezone"`
+	Created_at        string  `json:"created_at"`
+	Updated_at        string  `json:"updated_at"`
+}
+
+type UpdateProfile struct {
+	Timezone *string `json:"timezone"`
+}
+
+type ChangePassword struct {
+	Current_password string `json:"current_password"`
+	New_password     string `json:"new_password"`
+}
+
+type ChangeEmail struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// EmailChange is a change of email waiting for confirmation.
+type EmailChange struct {
+	UID   int    `json:"uid"`
+	Email string `json:"email"`
+}
+
+// DeleteAccount confirms the deletion with the password. Users without one,
+// who only sign in with single sign-on, log in again shortly before instead.
+type DeleteAccount struct {
+	Password string `json:"password"`
+}
+
 type DBtask struct {
 	ID         string `json:"id"`
 	User_ID    string `json:"user_id"`
@@ -40,20 +351,70 @@ type DBtask struct {
 	Updated_at string `json:"updated_at"`
 	Priority   string `json:"priority"`
 	Category   string `json:"category"`
+	// Completed_at is nil for open tasks
+	Completed_at *string `json:"completed_at"`
 }
 
 type DBuser struct {
-	UID        int    `json:"id"`
-	Email      string `json:"email"`
-	Password   string `json:"password"`
-	Created_at string `json:"created_at"`
-	Updated_at string `json:"updated_at"`
+	UID               int     `json:"id"`
+	Email             string  `json:"email"`
+	Password          string  `json:"password"`
+	Email_verified_at *string `json:"email_verified_at"`
+	Two_factor        bool    `json:"two_factor"`
+	Timezone          string  `json:"timezone"`
+	Created_at        string  `json:"created_at"`
+	Updated_at        string  `json:"updated_at"`
+}
+
+// RateLimit is the state of a rate limit bucket after a request. Reset is
+// how long until the bucket is full again.
+type RateLimit struct {
+	Allowed     bool
+	Remaining   int
+	Retry_after time.Duration
+	Reset       time.Duration
 }
 
 type Session struct {
-	UID int    `json:"uid"`
-	IAT int64  `json:"iat"`
-	EXP int64  `json:"exp"`
-	IP  string `json:"ip"`
-	UA  string `json:"ua"`
+	UID       int    `json:"uid"`
+	IAT       int64  `json:"iat"`
+	EXP       int64  `json:"exp"`
+	Last_seen int64  `json:"last_seen"`
+	IP        string `json:"ip"`
+	UA        string `json:"ua"`
+	// Verified is whether the email was verified, for AuthMiddleWare to
+	// apply the unverified policy without a database read
+	Verified bool `json:"verified"`
+	// CSRF is the token unsafe requests made with the session cookie have to
+	// carry in the X-CSRF-Token header
+	CSRF string `json:"csrf"`
+}
+
+type CSRFToken struct {
+	Token string `json:"csrf_token"`
+}
+
+// SessionInfo is a session as listed to its user. ID is derived from the
+// session id, which is a bearer secret and never shown.
+type SessionInfo struct {
+	ID           string `json:"id"`
+	Device       string `json:"device"`
+	IP           string `json:"ip"`
+	Created_at   string `json:"created_at"`
+	Last_seen_at string `json:"last_seen_at"`
+	Expires_at   string `json:"expires_at"`
+	Current      bool   `json:"current"`
+}
+
+type DAVtask struct {
+	DBtask
+	Name string
+	UID  string
+}
+
+// OIDCLogin is a login started at the provider, by its state, until the
+// provider redirects back.
+type OIDCLogin struct {
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
 }
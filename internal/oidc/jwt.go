@@ -0,0 +1,294 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("oidc: invalid id token")
+
+// Clock skew tolerated between the provider and us
+const leeway = time.Minute
+
+// Unknown key ids refetch the key set, as providers rotate keys, but no more
+// often than this
+const keysRefetchInterval = time.Minute
+
+type publicKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parse supports the keys of RS256 and ES256, the algorithms ID tokens are
+// accepted in.
+func (k jwk) parse() (publicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return publicKey{}, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return publicKey{}, errors.New("invalid RSA exponent")
+		}
+
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < 2048 {
+			return publicKey{}, errors.New("RSA key too short")
+		}
+
+		return publicKey{alg: "RS256", key: key}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return publicKey{}, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return publicKey{}, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return publicKey{}, err
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return publicKey{}, errors.New("EC point not on curve")
+		}
+
+		return publicKey{alg: "ES256", key: key}, nil
+	default:
+		return publicKey{}, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func (p *Provider) fetchKeys(ctx context.Context, jwks_uri string) (map[string]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	err := p.getJSON(ctx, jwks_uri, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]publicKey{}
+
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.parse()
+		if err != nil {
+			continue
+		}
+
+		if k.Alg != "" && k.Alg != key.alg {
+			continue
+		}
+
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+// key returns the signing key by id, fetching the key set when it isn't
+// known yet.
+func (p *Provider) key(ctx context.Context, kid string) (publicKey, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return publicKey{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(p.keys_fetched) < keysRefetchInterval {
+		return publicKey{}, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+
+	keys, err := p.fetchKeys(ctx, metadata.JWKSURI)
+	if err != nil {
+		return publicKey{}, fmt.Errorf("oidc: fetch keys: %w", err)
+	}
+
+	p.keys = keys
+	p.keys_fetched = time.Now()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	return publicKey{}, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+}
+
+// audience is a single string or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	*a = list
+	return nil
+}
+
+// flexBool is a boolean some providers send as a string.
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	data = bytes.Trim(data, `"`)
+	*b = flexBool(string(data) == "true")
+	return nil
+}
+
+// Claims are the ID token claims in use, once verified.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AZP           string   `json:"azp"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified flexBool `json:"email_verified"`
+}
+
+// Verify checks the signature of an ID token against the provider's keys
+// and its claims against this client and the nonce of the login.
+func (p *Provider) Verify(ctx context.Context, raw_token string, nonce string) (Claims, error) {
+	var claims Claims
+
+	parts := strings.Split(raw_token, ".")
+	if len(parts) != 3 {
+		return claims, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return claims, err
+	}
+
+	// The algorithm is the key's, never taken from the token alone
+	if header.Alg != key.alg {
+		return claims, fmt.Errorf("%w: algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	if !verifySignature(key, digest[:], signature) {
+		return claims, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+
+	now := time.Now()
+
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.cfg.Issuer:
+		return claims, fmt.Errorf("%w: issuer %q", ErrInvalidToken, claims.Issuer)
+	case !slices.Contains(claims.Audience, p.cfg.ClientID):
+		return claims, fmt.Errorf("%w: audience", ErrInvalidToken)
+	case len(claims.Audience) > 1 && claims.AZP != p.cfg.ClientID:
+		return claims, fmt.Errorf("%w: authorized party %q", ErrInvalidToken, claims.AZP)
+	case claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(leeway)):
+		return claims, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(leeway)):
+		return claims, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return claims, fmt.Errorf("%w: nonce", ErrInvalidToken)
+	case claims.Subject == "":
+		return claims, fmt.Errorf("%w: subject missing", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func verifySignature(key publicKey, digest []byte, signature []byte) bool {
+	switch pub := key.key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		// JWS signatures are r and s as fixed size big-endian integers
+		if len(signature) != 64 {
+			return false
+		}
+
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+
+		return ecdsa.Verify(pub, digest, r, s)
+	default:
+		return false
+	}
+}
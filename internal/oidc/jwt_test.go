@@ -0,0 +1,217 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://issuer.test"
+	testClientID = "todo"
+	testNonce    = "n-0S6_WzA2Mj"
+)
+
+// testProvider has its discovery and keys filled in, so that Verify needs
+// no provider to talk to.
+func testProvider(t *testing.T) (*Provider, *rsa.PrivateKey, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	rsa_key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec_key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(Config{Issuer: testIssuer + "/", ClientID: testClientID})
+	p.metadata = &Metadata{Issuer: testIssuer, JWKSURI: testIssuer + "/jwks"}
+	p.keys = map[string]publicKey{
+		"rsa": {alg: "RS256", key: &rsa_key.PublicKey},
+		"ec":  {alg: "ES256", key: &ec_key.PublicKey},
+	}
+	p.keys_fetched = time.Now()
+
+	return p, rsa_key, ec_key
+}
+
+func sign(t *testing.T, header map[string]any, claims map[string]any, key any) string {
+	t.Helper()
+
+	segment := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signing_input := segment(header) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signing_input))
+
+	var signature []byte
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+
+	return signing_input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerify(t *testing.T) {
+	p, rsa_key, ec_key := testProvider(t)
+
+	other_key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	valid := map[string]any{
+		"iss":            testIssuer,
+		"sub":            "248289761001",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          testNonce,
+		"email":          "jane@example.com",
+		"email_verified": "true",
+	}
+
+	with := func(changes map[string]any) map[string]any {
+		claims := maps.Clone(valid)
+
+		for k, v := range changes {
+			if v == nil {
+				delete(claims, k)
+				continue
+			}
+
+			claims[k] = v
+		}
+
+		return claims
+	}
+
+	rs256 := map[string]any{"alg": "RS256", "kid": "rsa"}
+	es256 := map[string]any{"alg": "ES256", "kid": "ec"}
+
+	tests := []struct {
+		name   string
+		header map[string]any
+		claims map[string]any
+		key    any
+		nonce  string
+		ok     bool
+	}{
+		{"RS256", rs256, valid, rsa_key, testNonce, true},
+		{"ES256", es256, valid, ec_key, testNonce, true},
+		{"issuer with trailing slash", rs256, with(map[string]any{"iss": testIssuer + "/"}), rsa_key, testNonce, true},
+		{"alg of another key", map[string]any{"alg": "ES256", "kid": "rsa"}, valid, rsa_key, testNonce, false},
+		{"alg none", map[string]any{"alg": "none", "kid": "rsa"}, valid, rsa_key, testNonce, false},
+		{"kid of another key", map[string]any{"alg": "ES256", "kid": "ec"}, valid, rsa_key, testNonce, false},
+		{"unknown kid", map[string]any{"alg": "RS256", "kid": "other"}, valid, rsa_key, testNonce, false},
+		{"signed with another key", es256, valid, other_key, testNonce, false},
+		{"other issuer", rs256, with(map[string]any{"iss": "https://evil.test"}), rsa_key, testNonce, false},
+		{"other audience", rs256, with(map[string]any{"aud": "another-client"}), rsa_key, testNonce, false},
+		{"audiences with us as azp", rs256, with(map[string]any{"aud": []string{testClientID, "api"}, "azp": testClientID}), rsa_key, testNonce, true},
+		{"audiences without azp", rs256, with(map[string]any{"aud": []string{testClientID, "api"}}), rsa_key, testNonce, false},
+		{"audiences with another azp", rs256, with(map[string]any{"aud": []string{testClientID, "api"}, "azp": "api"}), rsa_key, testNonce, false},
+		{"other nonce", rs256, valid, rsa_key, "another-nonce", false},
+		{"no nonce", rs256, with(map[string]any{"nonce": nil}), rsa_key, testNonce, false},
+		{"expired", rs256, with(map[string]any{"exp": now.Add(-2 * leeway).Unix()}), rsa_key, testNonce, false},
+		{"expired within leeway", rs256, with(map[string]any{"exp": now.Add(-leeway / 2).Unix()}), rsa_key, testNonce, true},
+		{"no expiry", rs256, with(map[string]any{"exp": nil}), rsa_key, testNonce, false},
+		{"issued in the future", rs256, with(map[string]any{"iat": now.Add(2 * leeway).Unix()}), rsa_key, testNonce, false},
+		{"no subject", rs256, with(map[string]any{"sub": nil}), rsa_key, testNonce, false},
+	}
+
+	for _, test := range tests {
+		raw_token := sign(t, test.header, test.claims, test.key)
+
+		claims, err := p.Verify(context.Background(), raw_token, test.nonce)
+		if test.ok {
+			if err != nil {
+				t.Errorf("%s: Verify() error: %v", test.name, err)
+				continue
+			}
+
+			if claims.Subject != "248289761001" || claims.Email != "jane@example.com" || !bool(claims.EmailVerified) {
+				t.Errorf("%s: Verify() claims = %+v", test.name, claims)
+			}
+			continue
+		}
+
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: Verify() error = %v, want ErrInvalidToken", test.name, err)
+		}
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	p, rsa_key, _ := testProvider(t)
+
+	raw_token := sign(t, map[string]any{"alg": "RS256", "kid": "rsa"}, map[string]any{
+		"iss":   testIssuer,
+		"sub":   "248289761001",
+		"aud":   testClientID,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": testNonce,
+	}, rsa_key)
+
+	other := sign(t, map[string]any{"alg": "RS256", "kid": "rsa"}, map[string]any{
+		"iss":   testIssuer,
+		"sub":   "1",
+		"aud":   testClientID,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": testNonce,
+	}, rsa_key)
+
+	parts := strings.Split(raw_token, ".")
+	other_parts := strings.Split(other, ".")
+
+	tests := []string{
+		// Claims of one token with the signature of another
+		other_parts[0] + "." + other_parts[1] + "." + parts[2],
+		raw_token[:len(raw_token)-4],
+		raw_token + ".",
+		"not a token",
+	}
+
+	for _, test := range tests {
+		if _, err := p.Verify(context.Background(), test, testNonce); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Verify(%.20q...) error = %v, want ErrInvalidToken", test, err)
+		}
+	}
+}
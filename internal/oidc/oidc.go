@@ -0,0 +1,212 @@
+// Package oidc is an OpenID Connect relying party for the authorization code
+// flow with PKCE, enough for single sign-on against one provider.
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Metadata is the part of the provider's discovery document in use.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider discovers its endpoints and keys on first use, so that the app
+// starts while the provider is unreachable.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu           sync.Mutex
+	metadata     *Metadata
+	keys         map[string]publicKey
+	keys_fetched time.Time
+}
+
+var ErrDiscovery = errors.New("oidc: discovery failed")
+
+func New(cfg Config) *Provider {
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &Provider{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p *Provider) Issuer() string { return p.cfg.Issuer }
+
+func (p *Provider) discover(ctx context.Context) (Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return *p.metadata, nil
+	}
+
+	var metadata Metadata
+
+	err := p.getJSON(ctx, p.cfg.Issuer+"/.well-known/openid-configuration", &metadata)
+	if err != nil {
+		return metadata, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+
+	// The issuer of the document has to be the one configured, for ID tokens
+	// to be checked against it
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.cfg.Issuer {
+		return metadata, fmt.Errorf("%w: issuer %q does not match", ErrDiscovery, metadata.Issuer)
+	}
+
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return metadata, fmt.Errorf("%w: endpoints missing", ErrDiscovery)
+	}
+
+	p.metadata = &metadata
+
+	return metadata, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", endpoint, res.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+// Challenge is the S256 PKCE challenge of a code verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where the user is sent to log in with the provider. A login
+// hint, when given, is passed on for the provider to prefill.
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string, login_hint string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	auth_url, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+
+	query := auth_url.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", Challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	if login_hint != "" {
+		query.Set("login_hint", login_hint)
+	}
+
+	auth_url.RawQuery = query.Encode()
+
+	return auth_url.String(), nil
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange redeems an authorization code for the ID token.
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	// Public clients identify themselves in the body, confidential ones
+	// with client_secret_basic
+	if p.cfg.ClientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer res.Body.Close()
+
+	var token_res tokenResponse
+
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&token_res)
+	if err != nil {
+		return "", fmt.Errorf("oidc: token response: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK || token_res.Error != "" {
+		return "", fmt.Errorf("oidc: token request: %s %s %s", res.Status, token_res.Error, token_res.ErrorDescription)
+	}
+
+	if token_res.IDToken == "" {
+		return "", errors.New("oidc: no id_token in token response")
+	}
+
+	return token_res.IDToken, nil
+}
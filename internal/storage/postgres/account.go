@@ -0,0 +1,176 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"todo/internal/models"
+	"todo/internal/utils/password"
+
+	"github.com/lib/pq"
+)
+
+var ErrEmailTaken = errors.New("email already taken")
+
+func SelectUser(DB *sql.DB, ctx context.Context, user_id int) (models.DBuser, error) {
+	var user models.DBuser
+
+	row := DB.QueryRowContext(ctx, "SELECT id, email, hashed_password, email_verified_at, totp_enabled_at IS NOT NULL, timezone, created_at, updated_at FROM users WHERE id = $1", user_id)
+
+	err := row.Scan(
+		&user.UID,
+		&user.Email,
+		&user.Password,
+		&user.Email_verified_at,
+		&user.Two_factor,
+		&user.Timezone,
+		&user.Created_at,
+		&user.Updated_at,
+	)
+
+	return user, err
+}
+
+func GetUserTimezone(DB *sql.DB, ctx context.Context, user_id int) (string, error) {
+	var timezone string
+
+	row := DB.QueryRowContext(ctx, "SELECT timezone FROM users WHERE id = $1", user_id)
+	err := row.Scan(&timezone)
+
+	return timezone, err
+}
+
+func SetUserTimezone(DB *sql.DB, ctx context.Context, user_id int, timezone string) error {
+	_, err := DB.ExecContext(ctx, "UPDATE users SET timezone = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", timezone, user_id)
+	return err
+}
+
+func StreamUserTasks(DB *sql.DB, ctx context.Context, user_id int, fn func(models.DBtask) error) error {
+	rows, err := DB.QueryContext(ctx, "SELECT id, user_id, title, completed, due_date, created_at, updated_at, priority, category, " + completedAtColumn + " FROM tasks WHERE user_id = $1 ORDER BY created_at", user_id)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var task models.DBtask
+
+		if err := rows.Scan(
+			&task.ID,
+			&task.User_ID,
+			&task.Title,
+			&task.Completed,
+			&task.Due_date,
+			&task.Created_at,
+			&task.Updated_at,
+			&task.Priority,
+			&task.Category,
+			&task.Completed_at,
+		); err != nil {
+			return err
+		}
+
+		if err := fn(task); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// DeleteUser removes the user row; everything the user owns is removed by
+// the ON DELETE CASCADE references.
+func DeleteUser(DB *sql.DB, ctx context.Context, user_id int) error {
+	_, err := DB.ExecContext(ctx, "DELETE FROM users WHERE id = $1", user_id)
+	return err
+}
+
+// ScheduleUserDeletion marks the user for purging and revokes the
+// credentials that don't go through a session right away.
+func ScheduleUserDeletion(DB *sql.DB, ctx context.Context, user_id int, delete_after time.Time) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET delete_after = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", delete_after, user_id); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM app_passwords WHERE user_id = $1", user_id); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM feed_tokens WHERE user_id = $1", user_id); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM access_tokens WHERE user_id = $1", user_id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func CancelUserDeletion(DB *sql.DB, ctx context.Context, user_id int) (bool, error) {
+	res, err := DB.ExecContext(ctx, "UPDATE users SET delete_after = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND delete_after IS NOT NULL", user_id)
+	if err != nil {
+		return false, err
+	}
+
+	rows_affected, err := res.RowsAffected()
+
+	return rows_affected > 0, err
+}
+
+func PurgeDeletedUsers(DB *sql.DB, ctx context.Context) (int64, error) {
+	res, err := DB.ExecContext(ctx, "DELETE FROM users WHERE delete_after IS NOT NULL AND delete_after <= CURRENT_TIMESTAMP")
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func SetPassword(DB *sql.DB, ctx context.Context, user_id int, new_password string) error {
+	hashed_password, err := password.Hash([]byte(new_password))
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.ExecContext(ctx, "UPDATE users SET hashed_password = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", hashed_password, user_id)
+
+	return err
+}
+
+func IsEmailVerified(DB *sql.DB, ctx context.Context, user_id int) (bool, error) {
+	var verified bool
+
+	row := DB.QueryRowContext(ctx, "SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1", user_id)
+	err := row.Scan(&verified)
+
+	return verified, err
+}
+
+func SetEmailVerified(DB *sql.DB, ctx context.Context, user_id int) error {
+	_, err := DB.ExecContext(ctx, "UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP WHERE id = $1", user_id)
+	return err
+}
+
+// ChangeEmail relies on the users.email constraint rather than a check first,
+// so that two accounts can't race to the same address. The new address was
+// confirmed through a link, so it counts as verified.
+func ChangeEmail(DB *sql.DB, ctx context.Context, user_id int, email string) error {
+	_, err := DB.ExecContext(ctx, "UPDATE users SET email = $1, email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $2", email, user_id)
+
+	var pq_err *pq.Error
+
+	if errors.As(err, &pq_err) && pq_err.Code == "23505" {
+		return ErrEmailTaken
+	}
+
+	return err
+}
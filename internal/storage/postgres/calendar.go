@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"todo/internal/models"
+)
+
+func SetFeedToken(DB *sql.DB, ctx context.Context, user_id int, token_hash string) error {
+	_, err := DB.ExecContext(ctx, `INSERT INTO feed_tokens (user_id, token_hash) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET token_hash = EXCLUDED.token_hash, created_at = CURRENT_TIMESTAMP`,
+		user_id, token_hash)
+
+	return err
+}
+
+func RemoveFeedToken(DB *sql.DB, ctx context.Context, user_id int) (int64, error) {
+	res, err := DB.ExecContext(ctx, "DELETE FROM feed_tokens WHERE user_id = $1", user_id)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func GetFeedTokenUserID(DB *sql.DB, ctx context.Context, token_hash string) (int, error) {
+	var user_id int
+
+	row := DB.QueryRowContext(ctx, "SELECT user_id FROM feed_tokens WHERE token_hash = $1", token_hash)
+	err := row.Scan(&user_id)
+
+	return user_id, err
+}
+
+// completedAtColumn is when a completed task was completed. Tasks completed
+// before that was recorded fall back on their last update, as in the views.
+const completedAtColumn = "CASE WHEN completed THEN COALESCE(completed_at, updated_at) END"
+
+func SelectDBTasks(DB *sql.DB, ctx context.Context, query_params string, args []any) ([]models.DBtask, error) {
+	query := "SELECT id, user_id, title, completed, due_date, created_at, updated_at, priority, category, " + completedAtColumn + " FROM tasks" + query_params
+
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var tasks []models.DBtask
+
+	for rows.Next() {
+		var task models.DBtask
+
+		if err := rows.Scan(
+			&task.ID,
+			&task.User_ID,
+			&task.Title,
+			&task.Completed,
+			&task.Due_date,
+			&task.Created_at,
+			&task.Updated_at,
+			&task.Priority,
+			&task.Category,
+			&task.Completed_at,
+		); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
@@ -0,0 +1,151 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"todo/internal/models"
+)
+
+func CreateAppPassword(DB *sql.DB, ctx context.Context, user_id int, name string, password_hash string) (models.AppPassword, error) {
+	app_password := models.AppPassword{Name: name}
+
+	row := DB.QueryRowContext(ctx, "INSERT INTO app_passwords (user_id, name, password_hash) VALUES ($1, $2, $3) RETURNING id, created_at",
+		user_id, name, password_hash)
+
+	err := row.Scan(&app_password.ID, &app_password.Created_at)
+
+	return app_password, err
+}
+
+func SelectAppPasswords(DB *sql.DB, ctx context.Context, user_id int) ([]models.AppPassword, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT id, name, created_at, last_used_at FROM app_passwords WHERE user_id = $1 ORDER BY id", user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	app_passwords := []models.AppPassword{}
+
+	for rows.Next() {
+		var app_password models.AppPassword
+
+		if err := rows.Scan(
+			&app_password.ID,
+			&app_password.Name,
+			&app_password.Created_at,
+			&app_password.Last_used_at,
+		); err != nil {
+			return nil, err
+		}
+		app_passwords = append(app_passwords, app_password)
+	}
+	return app_passwords, rows.Err()
+}
+
+func RemoveAppPassword(DB *sql.DB, ctx context.Context, user_id int, id int) (int64, error) {
+	res, err := DB.ExecContext(ctx, "DELETE FROM app_passwords WHERE user_id = $1 AND id = $2", user_id, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// AuthenticateAppPassword resolves the user owning the app password and
+// records its use in the same statement.
+func AuthenticateAppPassword(DB *sql.DB, ctx context.Context, email string, password_hash string) (int, error) {
+	var user_id int
+
+	row := DB.QueryRowContext(ctx, `UPDATE app_passwords a SET last_used_at = CURRENT_TIMESTAMP
+		FROM users u WHERE a.user_id = u.id AND u.email = $1 AND a.password_hash = $2
+		RETURNING a.user_id`, email, password_hash)
+
+	err := row.Scan(&user_id)
+
+	return user_id, err
+}
+
+const davTaskColumns = "id, user_id, title, completed, due_date, created_at, updated_at, priority, category, " + completedAtColumn + ", COALESCE(dav_name, id::text || '.ics'), COALESCE(ical_uid, '')"
+
+func scanDAVTask(row interface{ Scan(...any) error }) (models.DAVtask, error) {
+	var task models.DAVtask
+
+	err := row.Scan(
+		&task.ID,
+		&task.User_ID,
+		&task.Title,
+		&task.Completed,
+		&task.Due_date,
+		&task.Created_at,
+		&task.Updated_at,
+		&task.Priority,
+		&task.Category,
+		&task.Completed_at,
+		&task.Name,
+		&task.UID,
+	)
+
+	return task, err
+}
+
+func SelectDAVTasks(DB *sql.DB, ctx context.Context, user_id int) ([]models.DAVtask, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT "+davTaskColumns+" FROM tasks WHERE user_id = $1 ORDER BY created_at", user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var tasks []models.DAVtask
+
+	for rows.Next() {
+		task, err := scanDAVTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// SelectDAVTask looks a task up by its resource name, which is the name the
+// client PUT it under, or "<id>.ics" for tasks created through the API.
+func SelectDAVTask(DB *sql.DB, ctx context.Context, user_id int, name string) (models.DAVtask, error) {
+	row := DB.QueryRowContext(ctx, "SELECT "+davTaskColumns+` FROM tasks
+		WHERE user_id = $1 AND (dav_name = $2 OR (dav_name IS NULL AND id::text || '.ics' = $2))`, user_id, name)
+
+	return scanDAVTask(row)
+}
+
+// InsertDAVTask adds a task in the first open or done status, unless that
+// status is at its WIP limit.
+func InsertDAVTask(DB *sql.DB, ctx context.Context, user_id int, name string, uid string, task models.NewTask, completed bool) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if err := checkInsertWIP(tx, ctx, user_id, completed, 1); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO tasks (user_id, title, due_date, priority, category, completed, completed_at, dav_name, ical_uid)
+		VALUES ($1, $2, $3, $4, $5, $6, CASE WHEN $6 THEN CURRENT_TIMESTAMP END, $7, $8)`,
+		user_id,
+		task.Title,
+		task.Due_date,
+		task.Priority,
+		task.Category,
+		completed,
+		name,
+		uid,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
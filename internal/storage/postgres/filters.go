@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"todo/internal/models"
+)
+
+const filterColumns = "id, name, query, position, created_at, updated_at"
+
+func scanFilter(row interface{ Scan(...any) error }) (models.Filter, error) {
+	var filter models.Filter
+
+	err := row.Scan(
+		&filter.ID,
+		&filter.Name,
+		&filter.Query,
+		&filter.Position,
+		&filter.Created_at,
+		&filter.Updated_at,
+	)
+
+	return filter, err
+}
+
+// InsertFilter appends the filter after the user's other filters.
+func InsertFilter(DB *sql.DB, ctx context.Context, user_id int, name string, query string) (models.Filter, error) {
+	row := DB.QueryRowContext(ctx, `INSERT INTO saved_filters (user_id, name, query, position)
+		VALUES ($1, $2, $3, (SELECT COALESCE(MAX(position), 0) + 1 FROM saved_filters WHERE user_id = $1))
+		RETURNING `+filterColumns, user_id, name, query)
+
+	return scanFilter(row)
+}
+
+func SelectFilters(DB *sql.DB, ctx context.Context, user_id int) ([]models.Filter, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT "+filterColumns+" FROM saved_filters WHERE user_id = $1 ORDER BY position, id", user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	filters := []models.Filter{}
+
+	for rows.Next() {
+		filter, err := scanFilter(rows)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, rows.Err()
+}
+
+func SelectFilter(DB *sql.DB, ctx context.Context, user_id int, id int) (models.Filter, error) {
+	row := DB.QueryRowContext(ctx, "SELECT "+filterColumns+" FROM saved_filters WHERE user_id = $1 AND id = $2", user_id, id)
+
+	return scanFilter(row)
+}
+
+func FilterExists(DB *sql.DB, ctx context.Context, user_id int, name string) bool {
+	found := 0
+
+	row := DB.QueryRowContext(ctx, "SELECT 1 FROM saved_filters WHERE user_id = $1 AND name = $2", user_id, name)
+
+	return row.Scan(&found) == nil
+}
+
+func UpdateFilter(DB *sql.DB, ctx context.Context, user_id int, filter models.Filter) error {
+	res, err := DB.ExecContext(ctx, "UPDATE saved_filters SET name = $1, query = $2, updated_at = CURRENT_TIMESTAMP WHERE user_id = $3 AND id = $4",
+		filter.Name, filter.Query, user_id, filter.ID)
+	if err != nil {
+		return err
+	}
+
+	if rows_affected, _ := res.RowsAffected(); rows_affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func RemoveFilter(DB *sql.DB, ctx context.Context, user_id int, id int) (int64, error) {
+	res, err := DB.ExecContext(ctx, "DELETE FROM saved_filters WHERE user_id = $1 AND id = $2", user_id, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// ReorderFilters sets the positions of the user's filters to the order of
+// ids, which must hold each of them exactly once.
+func ReorderFilters(DB *sql.DB, ctx context.Context, user_id int, ids []int) error {
+	return reorder(DB, ctx, "saved_filters", user_id, ids)
+}
@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// ErrEmailNotVerified is returned for a first login whose email the provider
+// did not verify, as it can't be trusted to link or create an account.
+var ErrEmailNotVerified = errors.New("provider did not verify the email")
+
+// ErrUnverifiedAccount is returned for a first login whose email belongs to a
+// user who never verified it. Whoever registered it may not own the email,
+// and linking would let them keep a password into the provider user's account.
+var ErrUnverifiedAccount = errors.New("an account with this email exists but its email is not verified")
+
+// LinkIdentity resolves the user of a provider account. An account seen for
+// the first time is linked to the user with its email, in any case, once that
+// user verified it, or provisioned as a new user without a password whose
+// email counts as verified. It reports whether a user was created.
+func LinkIdentity(DB *sql.DB, ctx context.Context, issuer string, subject string, email string, email_verified bool) (int, bool, error) {
+	var user_id int
+
+	row := DB.QueryRowContext(ctx, `UPDATE identities SET last_login_at = CURRENT_TIMESTAMP
+		WHERE issuer = $1 AND subject = $2 RETURNING user_id`, issuer, subject)
+
+	err := row.Scan(&user_id)
+	if err == nil {
+		return user_id, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+
+	if email == "" || !email_verified {
+		return 0, false, ErrEmailNotVerified
+	}
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+
+	defer tx.Rollback()
+
+	created := false
+	verified := true
+
+	row = tx.QueryRowContext(ctx, `SELECT id, email_verified_at IS NOT NULL FROM users
+		WHERE lower(email) = lower($1) ORDER BY email_verified_at IS NULL, id LIMIT 1 FOR UPDATE`, email)
+
+	err = row.Scan(&user_id, &verified)
+	if err == sql.ErrNoRows {
+		// An empty hash matches no password, one can be set with a reset
+		row = tx.QueryRowContext(ctx, `INSERT INTO users (email, hashed_password, email_verified_at)
+			VALUES ($1, '', CURRENT_TIMESTAMP) RETURNING id`, email)
+
+		err = row.Scan(&user_id)
+		created = true
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	if !verified {
+		return 0, false, ErrUnverifiedAccount
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO identities (user_id, issuer, subject, email, last_login_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)`, user_id, issuer, subject, email)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return user_id, created, tx.Commit()
+}
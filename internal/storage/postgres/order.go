@@ -0,0 +1,51 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var ErrOrder = errors.New("order must list every item exactly once")
+
+// reorder sets the position column of the user's rows in table to the order
+// of ids, which must hold each of them exactly once.
+func reorder(DB *sql.DB, ctx context.Context, table string, user_id int, ids []int) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var count int
+
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" WHERE user_id = $1", user_id).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count != len(ids) {
+		return ErrOrder
+	}
+
+	seen := map[int]bool{}
+
+	for ind, id := range ids {
+		if seen[id] {
+			return ErrOrder
+		}
+		seen[id] = true
+
+		res, err := tx.ExecContext(ctx, "UPDATE "+table+" SET position = $1 WHERE user_id = $2 AND id = $3", ind+1, user_id, id)
+		if err != nil {
+			return err
+		}
+
+		if rows_affected, _ := res.RowsAffected(); rows_affected == 0 {
+			return ErrOrder
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,163 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"todo/internal/utils/task"
+
+	"github.com/lib/pq"
+)
+
+// MoveTaskPosition places a task right before, or with after set right
+// after, an anchor task, in the position, id order tasks are listed in. Only
+// the moved task is written, unless the anchor and its neighbour share a
+// rank with none between them, when the user's ranks are spread again first.
+// The anchor is locked while the rank is picked, so that a rebalance can't
+// change the scale in between.
+func MoveTaskPosition(DB *sql.DB, ctx context.Context, user_id int, task_uuid string, anchor_uuid string, after bool) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var i int
+
+	row := tx.QueryRowContext(ctx, "SELECT 1 FROM tasks WHERE user_id = $1 AND id = $2 FOR UPDATE", user_id, task_uuid)
+	if err := row.Scan(&i); err != nil {
+		return err
+	}
+
+	neighbour_query := `SELECT position FROM tasks WHERE user_id = $1 AND id <> $2 AND (position, id) < ($3, $4)
+		ORDER BY position DESC, id DESC LIMIT 1`
+	if after {
+		neighbour_query = `SELECT position FROM tasks WHERE user_id = $1 AND id <> $2 AND (position, id) > ($3, $4)
+			ORDER BY position, id LIMIT 1`
+	}
+
+	for spread := false; ; spread = true {
+		var anchor string
+
+		row := tx.QueryRowContext(ctx, "SELECT position FROM tasks WHERE user_id = $1 AND id = $2 FOR SHARE", user_id, anchor_uuid)
+		if err := row.Scan(&anchor); err != nil {
+			return err
+		}
+
+		var neighbour string
+
+		err := tx.QueryRowContext(ctx, neighbour_query, user_id, task_uuid, anchor, anchor_uuid).Scan(&neighbour)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		low, high := neighbour, anchor
+		if after {
+			low, high = anchor, neighbour
+		}
+
+		// Moving to the end stays below the rank of tasks created from now on
+		if high == "" {
+			if next := task_utils.DefaultRank(time.Now()); next > low {
+				high = next
+			}
+		}
+
+		position, err := task_utils.RankBetween(low, high)
+		if err == task_utils.ErrRankOrder && !spread {
+			if err := spreadPositions(tx, ctx, user_id, false); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE tasks SET position = $1 WHERE user_id = $2 AND id = $3", position, user_id, task_uuid)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}
+}
+
+// SelectUnbalancedUsers returns users with ranks longer than max_length.
+func SelectUnbalancedUsers(DB *sql.DB, ctx context.Context, max_length int, limit int) ([]int, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT DISTINCT user_id FROM tasks WHERE length(position) > $1 LIMIT $2", max_length, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var user_ids []int
+
+	for rows.Next() {
+		var user_id int
+
+		if err := rows.Scan(&user_id); err != nil {
+			return nil, err
+		}
+		user_ids = append(user_ids, user_id)
+	}
+	return user_ids, rows.Err()
+}
+
+// RebalancePositions rewrites the ranks of a user's tasks evenly, keeping
+// their order. It gives up instead of waiting when any of the tasks is being
+// written, so the next run tries again.
+func RebalancePositions(DB *sql.DB, ctx context.Context, user_id int) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if err := spreadPositions(tx, ctx, user_id, true); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// spreadPositions rewrites the ranks of a user's tasks evenly within tx,
+// locking them all first, without waiting when nowait is set.
+func spreadPositions(tx *sql.Tx, ctx context.Context, user_id int, nowait bool) error {
+	query := "SELECT id FROM tasks WHERE user_id = $1 ORDER BY position, id FOR UPDATE"
+	if nowait {
+		query += " NOWAIT"
+	}
+
+	rows, err := tx.QueryContext(ctx, query, user_id)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tasks t SET position = v.position
+		FROM unnest($1::uuid[], $2::text[]) AS v(id, position) WHERE t.id = v.id`,
+		pq.Array(ids), pq.Array(task_utils.SpreadRanks(len(ids))))
+
+	return err
+}
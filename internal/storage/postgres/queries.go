@@ -9,7 +9,7 @@ import (
 )
 
 func SelectTasks(DB *sql.DB, ctx context.Context, query_params string, args []any) ([]models.Task, error) {
-	query := "SELECT title, completed, due_date, created_at, updated_at, priority, category FROM tasks" + query_params
+	query := "SELECT title, completed, due_date, created_at, updated_at, priority, category, " + statusColumn + " FROM tasks" + query_params
 
 	rows, err := DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -31,6 +31,7 @@ func SelectTasks(DB *sql.DB, ctx context.Context, query_params string, args []an
 			&task.Updated_at,
 			&task.Priority,
 			&task.Category,
+			&task.Status,
 		); err != nil {
 			return nil, err
 		}
@@ -39,24 +40,73 @@ func SelectTasks(DB *sql.DB, ctx context.Context, query_params string, args []an
 	return tasks, nil
 }
 
+func StreamTasks(DB *sql.DB, ctx context.Context, query_params string, args []any, fn func(models.Task) error) error {
+	query := "SELECT title, completed, due_date, created_at, updated_at, priority, category, " + statusColumn + " FROM tasks" + query_params
+
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var task models.Task
+
+		if err := rows.Scan(
+			&task.Title,
+			&task.Completed,
+			&task.Due_date,
+			&task.Created_at,
+			&task.Updated_at,
+			&task.Priority,
+			&task.Category,
+			&task.Status,
+		); err != nil {
+			return err
+		}
+
+		if err := fn(task); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// InsertTask adds an open task, unless the first open status is at its WIP
+// limit.
 func InsertTask(DB *sql.DB, ctx context.Context, user_id int, task models.NewTask) error {
 	task_utils.TrimSpace(&task)
 
-	_, err := DB.ExecContext(ctx, "INSERT INTO tasks (user_id, title, due_date, priority, category) values ($1, $2, $3, $4, $5)",
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if err := checkInsertWIP(tx, ctx, user_id, false, 1); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO tasks (user_id, title, due_date, priority, category) values ($1, $2, $3, $4, $5)",
 		user_id,
 		task.Title,
 		task.Due_date,
 		task.Priority,
 		task.Category,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return tx.Commit()
 }
 
 func SelectTask(DB *sql.DB, ctx context.Context, user_id int, task_uuid string) (models.Task, error) {
 	var task models.Task
 
-	row := DB.QueryRowContext(ctx, "SELECT title, completed, due_date, created_at, updated_at, priority, category FROM tasks WHERE user_id = $1 AND id = $2", user_id, task_uuid)
+	row := DB.QueryRowContext(ctx, "SELECT title, completed, due_date, created_at, updated_at, priority, category, " + statusColumn + " FROM tasks WHERE user_id = $1 AND id = $2", user_id, task_uuid)
 
 	if err := row.Scan(
 		&task.Title,
@@ -66,6 +116,7 @@ func SelectTask(DB *sql.DB, ctx context.Context, user_id int, task_uuid string)
 		&task.Updated_at,
 		&task.Priority,
 		&task.Category,
+		&task.Status,
 	); err != nil {
 		return task, err
 	}
@@ -151,6 +202,19 @@ func GetPassword(DB *sql.DB, ctx context.Context, email string) (string, error)
 	return hashed_password, err
 }
 
+// CountLegacyPasswords counts the password hashes still in bcrypt, out of
+// all of them. Users without a password are left out.
+func CountLegacyPasswords(DB *sql.DB, ctx context.Context) (int, int, error) {
+	var legacy, total int
+
+	row := DB.QueryRowContext(ctx, `SELECT count(*) FILTER (WHERE hashed_password NOT LIKE '$argon2id$%'), count(*)
+		FROM users WHERE hashed_password <> ''`)
+
+	err := row.Scan(&legacy, &total)
+
+	return legacy, total, err
+}
+
 func GetUserID(DB *sql.DB, ctx context.Context, email string) (int, error) {
 	var id int
 
@@ -162,7 +226,7 @@ func GetUserID(DB *sql.DB, ctx context.Context, email string) (int, error) {
 }
 
 func SelectAllTasks(DB *sql.DB, ctx context.Context) ([]models.DBtask, error) {
-	rows, err := DB.QueryContext(ctx, "SELECT * FROM tasks")
+	rows, err := DB.QueryContext(ctx, "SELECT id, user_id, title, completed, due_date, created_at, updated_at, priority, category, " + completedAtColumn + " FROM tasks")
 	if err != nil {
 		return nil, err
 	}
@@ -184,6 +248,7 @@ func SelectAllTasks(DB *sql.DB, ctx context.Context) ([]models.DBtask, error) {
 			&task.Updated_at,
 			&task.Priority,
 			&task.Category,
+			&task.Completed_at,
 		); err != nil {
 			return nil, err
 		}
@@ -193,7 +258,7 @@ func SelectAllTasks(DB *sql.DB, ctx context.Context) ([]models.DBtask, error) {
 }
 
 func SelectAllUsers(DB *sql.DB, ctx context.Context) ([]models.DBuser, error) {
-	rows, err := DB.QueryContext(ctx, "SELECT * FROM users")
+	rows, err := DB.QueryContext(ctx, "SELECT id, email, hashed_password, email_verified_at, totp_enabled_at IS NOT NULL, timezone, created_at, updated_at FROM users")
 	if err != nil {
 		return nil, err
 	}
@@ -209,6 +274,9 @@ func SelectAllUsers(DB *sql.DB, ctx context.Context) ([]models.DBuser, error) {
 			&user.UID,
 			&user.Email,
 			&user.Password,
+			&user.Email_verified_at,
+			&user.Two_factor,
+			&user.Timezone,
 			&user.Created_at,
 			&user.Updated_at,
 		); err != nil {
@@ -218,3 +286,17 @@ func SelectAllUsers(DB *sql.DB, ctx context.Context) ([]models.DBuser, error) {
 	}
 	return users, nil
 }
+
+// SelectCounts runs a query returning a single row of n counts.
+func SelectCounts(DB *sql.DB, ctx context.Context, query string, args []any, n int) ([]int, error) {
+	counts := make([]int, n)
+	dest := make([]any, n)
+
+	for i := range counts {
+		dest[i] = &counts[i]
+	}
+
+	err := DB.QueryRowContext(ctx, query, args...).Scan(dest...)
+
+	return counts, err
+}
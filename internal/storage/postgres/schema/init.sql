@@ -1,25 +1,177 @@
+-- The schema of a new database. The script can be run again on an existing
+-- one, which the migrations at the end bring up to date.
+
 CREATE EXTENSION IF NOT EXISTS "pgcrypto";
 
 CREATE TABLE IF NOT EXISTS users (
     id              SERIAL PRIMARY KEY,
     email           TEXT UNIQUE NOT NULL,
     hashed_password TEXT NOT NULL,
+    email_verified_at TIMESTAMPTZ,
+    totp_secret     TEXT,
+    totp_enabled_at TIMESTAMPTZ,
+    delete_after    TIMESTAMPTZ,
+    timezone        TEXT NOT NULL DEFAULT 'UTC',
     created_at      TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
     updated_at      TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE TABLE IF NOT EXISTS statuses (
+    id          SERIAL PRIMARY KEY,
+    user_id     INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name        TEXT NOT NULL,
+    position    INTEGER NOT NULL,
+    done        BOOLEAN NOT NULL DEFAULT false,
+    wip_limit   INTEGER,
+    transitions INTEGER[],
+    UNIQUE(user_id, name)
+);
+
 CREATE TABLE IF NOT EXISTS tasks (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
     title TEXT NOT NULL,
     completed BOOLEAN DEFAULT false,
+    completed_at TIMESTAMPTZ,
+    status_id INTEGER REFERENCES statuses(id) ON DELETE SET NULL,
+    -- Lexicographic rank for manual ordering, see task_utils.DefaultRank
+    position TEXT COLLATE "C" NOT NULL DEFAULT ('t' || to_char(clock_timestamp() AT TIME ZONE 'UTC', 'YYYYMMDDHH24MISSUS') || 'i'),
     due_date TIMESTAMPTZ NOT NULL,
     created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
     priority TEXT DEFAULT 'medium' CHECK (priority IN ('low', 'medium', 'high')),
     category TEXT NOT NULL,
-    UNIQUE(user_id, title)
+    dav_name TEXT,
+    ical_uid TEXT,
+    todotxt_extras TEXT NOT NULL DEFAULT '',
+    UNIQUE(user_id, title),
+    UNIQUE(user_id, dav_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tasks_user_id ON tasks(user_id);
+
+
+CREATE TABLE IF NOT EXISTS saved_filters (
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name       TEXT NOT NULL,
+    query      TEXT NOT NULL,
+    position   INTEGER NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS templates (
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name       TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS template_tasks (
+    id          SERIAL PRIMARY KEY,
+    template_id INTEGER NOT NULL REFERENCES templates(id) ON DELETE CASCADE,
+    position    INTEGER NOT NULL,
+    title       TEXT NOT NULL,
+    category    TEXT NOT NULL,
+    priority    TEXT NOT NULL,
+    due_offset  TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_template_tasks_template_id ON template_tasks(template_id);
+
+CREATE TABLE IF NOT EXISTS feed_tokens (
+    user_id    INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT UNIQUE NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE INDEX idx_tasks_user_id ON tasks(user_id);
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id        SERIAL PRIMARY KEY,
+    user_id   INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at   TIMESTAMPTZ,
+    UNIQUE(user_id, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS app_passwords (
+    id            SERIAL PRIMARY KEY,
+    user_id       INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name          TEXT NOT NULL,
+    password_hash TEXT UNIQUE NOT NULL,
+    created_at    TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    last_used_at  TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_app_passwords_user_id ON app_passwords(user_id);
+
+CREATE TABLE IF NOT EXISTS access_tokens (
+    id           SERIAL PRIMARY KEY,
+    user_id      INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    token_hash   TEXT UNIQUE NOT NULL,
+    scopes       TEXT[] NOT NULL,
+    expires_at   TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_tokens_user_id ON access_tokens(user_id);
+
+-- Accounts at an OpenID Connect provider, by the provider's subject id,
+-- which unlike the email never changes
+CREATE TABLE IF NOT EXISTS identities (
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    issuer     TEXT NOT NULL,
+    subject    TEXT NOT NULL,
+    email      TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMPTZ,
+    UNIQUE(issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_identities_user_id ON identities(user_id);
+
+-- Migrations of databases created before these columns. Backfills only run
+-- when their column is added, so running the script again changes nothing.
+
+DO $$
+BEGIN
+    -- Accounts from before email verification keep full access
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'email_verified_at') THEN
+        ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+        UPDATE users SET email_verified_at = created_at;
+    END IF;
+
+    -- Existing tasks keep their creation order
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'tasks' AND column_name = 'position') THEN
+        ALTER TABLE tasks ADD COLUMN position TEXT COLLATE "C";
+        UPDATE tasks SET position = 't' || to_char(created_at AT TIME ZONE 'UTC', 'YYYYMMDDHH24MISSUS') || 'i';
+        ALTER TABLE tasks ALTER COLUMN position SET NOT NULL,
+            ALTER COLUMN position SET DEFAULT ('t' || to_char(clock_timestamp() AT TIME ZONE 'UTC', 'YYYYMMDDHH24MISSUS') || 'i');
+    END IF;
+END $$;
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS totp_secret TEXT,
+    ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS delete_after TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';
+
+-- Tasks completed before completed_at keep it NULL, and fall back on their
+-- last update where it is read
+ALTER TABLE tasks
+    ADD COLUMN IF NOT EXISTS completed_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS status_id INTEGER REFERENCES statuses(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS dav_name TEXT,
+    ADD COLUMN IF NOT EXISTS ical_uid TEXT,
+    ADD COLUMN IF NOT EXISTS todotxt_extras TEXT NOT NULL DEFAULT '';
+
+-- Named as the UNIQUE constraint of CREATE TABLE, so only one of them exists
+CREATE UNIQUE INDEX IF NOT EXISTS tasks_user_id_dav_name_key ON tasks(user_id, dav_name);
 
+CREATE INDEX IF NOT EXISTS idx_tasks_user_position ON tasks(user_id, position);
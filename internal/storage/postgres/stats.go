@@ -0,0 +1,152 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"todo/internal/models"
+)
+
+// Tasks completed before completed_at existed fall back to their last update.
+const statsTasks = "(SELECT *, COALESCE(completed_at, updated_at) AS done FROM tasks WHERE user_id = $1) t"
+
+// The stats queries take the user id, the first and last day of the range
+// and the timezone the days are in, as $1 to $4.
+
+const statsSummaryQuery = `SELECT
+	COUNT(*) FILTER (WHERE (created_at AT TIME ZONE $4)::date BETWEEN $2 AND $3),
+	COUNT(*) FILTER (WHERE completed AND (done AT TIME ZONE $4)::date BETWEEN $2 AND $3),
+	COALESCE(EXTRACT(EPOCH FROM AVG(done - created_at) FILTER (WHERE completed AND (done AT TIME ZONE $4)::date BETWEEN $2 AND $3)) / 3600, 0),
+	COUNT(*) FILTER (WHERE due_date < CURRENT_TIMESTAMP AND (due_date AT TIME ZONE $4)::date BETWEEN $2 AND $3),
+	COUNT(*) FILTER (WHERE due_date < CURRENT_TIMESTAMP AND (due_date AT TIME ZONE $4)::date BETWEEN $2 AND $3 AND (NOT completed OR done > due_date))
+	FROM ` + statsTasks
+
+const statsPerDayQuery = `SELECT to_char(d, 'YYYY-MM-DD'), COUNT(t.id)
+	FROM generate_series($2::timestamp, $3::timestamp, interval '1 day') d
+	LEFT JOIN ` + statsTasks + ` ON t.completed AND (t.done AT TIME ZONE $4)::date = d::date
+	GROUP BY d ORDER BY d`
+
+const statsPerWeekQuery = `SELECT to_char(date_trunc('week', done AT TIME ZONE $4), 'YYYY-MM-DD'), COUNT(*)
+	FROM ` + statsTasks + ` WHERE completed AND (done AT TIME ZONE $4)::date BETWEEN $2 AND $3
+	GROUP BY 1 ORDER BY 1`
+
+// A task is open at the end of a day when it was created before and not
+// completed by then.
+const statsBurndownQuery = `SELECT to_char(d, 'YYYY-MM-DD'), COUNT(t.id)
+	FROM generate_series($2::timestamp, $3::timestamp, interval '1 day') d
+	LEFT JOIN ` + statsTasks + ` ON t.created_at < (d + interval '1 day') AT TIME ZONE $4
+		AND (NOT t.completed OR t.done >= (d + interval '1 day') AT TIME ZONE $4)
+	GROUP BY d ORDER BY d`
+
+// A streak is a run of consecutive days with a completion, and is current
+// while it reaches today or yesterday. $2 is today's date.
+const statsStreaksQuery = `WITH days AS (
+		SELECT DISTINCT (done AT TIME ZONE $3)::date AS day FROM ` + statsTasks + ` WHERE completed
+	), runs AS (
+		SELECT day, day - (ROW_NUMBER() OVER (ORDER BY day))::int AS run FROM days
+	), streaks AS (
+		SELECT MAX(day) AS last_day, COUNT(*) AS length FROM runs GROUP BY run
+	)
+	SELECT COALESCE(MAX(length) FILTER (WHERE last_day >= $2::date - 1), 0), COALESCE(MAX(length), 0) FROM streaks`
+
+func statsBreakdownQuery(column string) string {
+	return `SELECT ` + column + `, COUNT(*), COUNT(*) FILTER (WHERE completed), COUNT(*) FILTER (WHERE NOT completed AND due_date < CURRENT_TIMESTAMP)
+	FROM tasks WHERE user_id = $1 GROUP BY 1 ORDER BY 2 DESC, 1`
+}
+
+func SelectStats(DB *sql.DB, ctx context.Context, user_id int, from string, to string, timezone string, today string) (models.Stats, error) {
+	stats := models.Stats{From: from, To: to, Timezone: timezone}
+
+	row := DB.QueryRowContext(ctx, statsSummaryQuery, user_id, from, to, timezone)
+
+	err := row.Scan(
+		&stats.Created,
+		&stats.Completed,
+		&stats.Avg_completion_hours,
+		&stats.Due,
+		&stats.Overdue,
+	)
+	if err != nil {
+		return stats, err
+	}
+
+	if stats.Due > 0 {
+		stats.Overdue_rate = float64(stats.Overdue) / float64(stats.Due)
+	}
+
+	stats.Completed_per_day, err = selectDateCounts(DB, ctx, statsPerDayQuery, user_id, from, to, timezone)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Completed_per_week, err = selectDateCounts(DB, ctx, statsPerWeekQuery, user_id, from, to, timezone)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Burndown, err = selectDateCounts(DB, ctx, statsBurndownQuery, user_id, from, to, timezone)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.By_category, err = selectBreakdown(DB, ctx, statsBreakdownQuery("category"), user_id)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.By_priority, err = selectBreakdown(DB, ctx, statsBreakdownQuery("priority"), user_id)
+	if err != nil {
+		return stats, err
+	}
+
+	row = DB.QueryRowContext(ctx, statsStreaksQuery, user_id, today, timezone)
+	err = row.Scan(&stats.Streaks.Current, &stats.Streaks.Longest)
+
+	return stats, err
+}
+
+func selectDateCounts(DB *sql.DB, ctx context.Context, query string, args ...any) ([]models.DateCount, error) {
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	date_counts := []models.DateCount{}
+
+	for rows.Next() {
+		var date_count models.DateCount
+
+		if err := rows.Scan(&date_count.Date, &date_count.Count); err != nil {
+			return nil, err
+		}
+		date_counts = append(date_counts, date_count)
+	}
+	return date_counts, rows.Err()
+}
+
+func selectBreakdown(DB *sql.DB, ctx context.Context, query string, user_id int) ([]models.Breakdown, error) {
+	rows, err := DB.QueryContext(ctx, query, user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	breakdowns := []models.Breakdown{}
+
+	for rows.Next() {
+		var breakdown models.Breakdown
+
+		if err := rows.Scan(
+			&breakdown.Key,
+			&breakdown.Total,
+			&breakdown.Completed,
+			&breakdown.Overdue,
+		); err != nil {
+			return nil, err
+		}
+		breakdowns = append(breakdowns, breakdown)
+	}
+	return breakdowns, rows.Err()
+}
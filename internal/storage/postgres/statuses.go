@@ -0,0 +1,229 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"todo/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// statusColumn resolves the status name of a task. Tasks that were never
+// moved, or whose status was deleted, are in the first open or done status
+// of the user depending on completed.
+const statusColumn = `COALESCE(
+	(SELECT s.name FROM statuses s WHERE s.id = tasks.status_id),
+	(SELECT s.name FROM statuses s WHERE s.user_id = tasks.user_id AND s.done = tasks.completed ORDER BY s.position, s.id LIMIT 1),
+	CASE WHEN tasks.completed THEN 'done' ELSE 'todo' END)`
+
+const statusColumns = "id, name, position, done, wip_limit, transitions"
+
+// EnsureStatuses gives a user the default todo, in progress and done
+// statuses unless they already have statuses.
+func EnsureStatuses(DB *sql.DB, ctx context.Context, user_id int) error {
+	_, err := DB.ExecContext(ctx, `INSERT INTO statuses (user_id, name, position, done)
+		SELECT $1, v.name, v.position, v.done FROM (VALUES ('todo', 1, false), ('in progress', 2, false), ('done', 3, true)) v(name, position, done)
+		WHERE NOT EXISTS (SELECT 1 FROM statuses WHERE user_id = $1)
+		ON CONFLICT (user_id, name) DO NOTHING`, user_id)
+
+	return err
+}
+
+func scanStatus(row interface{ Scan(...any) error }) (models.Status, error) {
+	var status models.Status
+
+	err := row.Scan(
+		&status.ID,
+		&status.Name,
+		&status.Position,
+		&status.Done,
+		&status.WIP_limit,
+		(*pq.Int64Array)(&status.Transition_IDs),
+	)
+
+	return status, err
+}
+
+func SelectStatuses(DB *sql.DB, ctx context.Context, user_id int) ([]models.Status, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT "+statusColumns+" FROM statuses WHERE user_id = $1 ORDER BY position, id", user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	statuses := []models.Status{}
+
+	for rows.Next() {
+		status, err := scanStatus(rows)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, rows.Err()
+}
+
+func InsertStatus(DB *sql.DB, ctx context.Context, user_id int, status models.Status) (models.Status, error) {
+	row := DB.QueryRowContext(ctx, `INSERT INTO statuses (user_id, name, position, done, wip_limit, transitions)
+		VALUES ($1, $2, (SELECT COALESCE(MAX(position), 0) + 1 FROM statuses WHERE user_id = $1), $3, $4, $5)
+		RETURNING `+statusColumns,
+		user_id, status.Name, status.Done, status.WIP_limit, transitionsArg(status.Transition_IDs))
+
+	return scanStatus(row)
+}
+
+// UpdateStatus stores the status and keeps completed in line with its done
+// flag for the tasks in it.
+func UpdateStatus(DB *sql.DB, ctx context.Context, user_id int, status models.Status) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE statuses SET name = $1, done = $2, wip_limit = $3, transitions = $4 WHERE user_id = $5 AND id = $6",
+		status.Name, status.Done, status.WIP_limit, transitionsArg(status.Transition_IDs), user_id, status.ID)
+	if err != nil {
+		return err
+	}
+
+	if rows_affected, _ := res.RowsAffected(); rows_affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tasks SET completed = $1, completed_at = CASE WHEN $1 THEN COALESCE(completed_at, CURRENT_TIMESTAMP) END,
+		updated_at = CURRENT_TIMESTAMP WHERE user_id = $2 AND status_id = $3 AND completed <> $1`,
+		status.Done, user_id, status.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveStatus deletes the status and drops it from the transitions of the
+// others. Its tasks fall back to the first open or done status.
+func RemoveStatus(DB *sql.DB, ctx context.Context, user_id int, id int) (int64, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM statuses WHERE user_id = $1 AND id = $2", user_id, id)
+	if err != nil {
+		return 0, err
+	}
+
+	rows_affected, _ := res.RowsAffected()
+	if rows_affected == 0 {
+		return 0, nil
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE statuses SET transitions = NULLIF(array_remove(transitions, $1), '{}') WHERE user_id = $2", id, user_id)
+	if err != nil {
+		return 0, err
+	}
+
+	return rows_affected, tx.Commit()
+}
+
+func ReorderStatuses(DB *sql.DB, ctx context.Context, user_id int, ids []int) error {
+	return reorder(DB, ctx, "statuses", user_id, ids)
+}
+
+var (
+	ErrStatusNotFound = errors.New("status not found")
+	ErrWIPLimit       = errors.New("status WIP limit reached")
+)
+
+// checkInsertWIP refuses added new tasks beyond the WIP limit of the status
+// they land in, the first open or done one depending on completed. That
+// status is locked until the insert commits, as in MoveTask.
+func checkInsertWIP(tx *sql.Tx, ctx context.Context, user_id int, completed bool, added int) error {
+	if added == 0 {
+		return nil
+	}
+
+	status, err := scanStatus(tx.QueryRowContext(ctx, "SELECT "+statusColumns+" FROM statuses WHERE user_id = $1 AND done = $2 ORDER BY position, id LIMIT 1 FOR UPDATE", user_id, completed))
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil || status.WIP_limit == nil {
+		return err
+	}
+
+	var count int
+
+	row := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM tasks WHERE user_id = $1 AND "+statusColumn+" = $2", user_id, status.Name)
+	if err := row.Scan(&count); err != nil {
+		return err
+	}
+
+	if count+added > *status.WIP_limit {
+		return fmt.Errorf("%w: %s holds %d tasks", ErrWIPLimit, status.Name, count)
+	}
+
+	return nil
+}
+
+// MoveTask runs an update that moves a task into the target status after
+// check accepted the move, given the target as stored, the current status
+// name of the task and the number of tasks in the target. The target row is
+// locked until the update commits, so that concurrent moves into it are
+// counted one after the other and can't exceed its WIP limit.
+func MoveTask(DB *sql.DB, ctx context.Context, user_id int, task_uuid string, target_id int, update_query string, args []any, check func(target models.Status, current string, count int) error) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	target, err := scanStatus(tx.QueryRowContext(ctx, "SELECT "+statusColumns+" FROM statuses WHERE user_id = $1 AND id = $2 FOR UPDATE", user_id, target_id))
+	if err == sql.ErrNoRows {
+		return ErrStatusNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var current string
+
+	row := tx.QueryRowContext(ctx, "SELECT "+statusColumn+" FROM tasks WHERE user_id = $1 AND id = $2 FOR UPDATE", user_id, task_uuid)
+	if err := row.Scan(&current); err != nil {
+		return err
+	}
+
+	var count int
+
+	row = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM tasks WHERE user_id = $1 AND "+statusColumn+" = $2", user_id, target.Name)
+	if err := row.Scan(&count); err != nil {
+		return err
+	}
+
+	if err := check(target, current, count); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, update_query, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// An empty list is stored as NULL, allowing every transition.
+func transitionsArg(ids []int64) any {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return pq.Int64Array(ids)
+}
@@ -0,0 +1,194 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"todo/internal/models"
+
+	"github.com/lib/pq"
+)
+
+func InsertTemplate(DB *sql.DB, ctx context.Context, user_id int, template models.Template) (int, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	var id int
+
+	err = tx.QueryRowContext(ctx, "INSERT INTO templates (user_id, name) VALUES ($1, $2) RETURNING id", user_id, template.Name).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertTemplateTasks(tx, ctx, id, template.Tasks)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func insertTemplateTasks(tx *sql.Tx, ctx context.Context, template_id int, tasks []models.TemplateTask) error {
+	for ind, task := range tasks {
+		_, err := tx.ExecContext(ctx, "INSERT INTO template_tasks (template_id, position, title, category, priority, due_offset) VALUES ($1, $2, $3, $4, $5, $6)",
+			template_id, ind+1, task.Title, task.Category, task.Priority, task.Due_offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SelectTemplates returns the user's templates with their tasks, or only the
+// template of id when it isn't 0.
+func SelectTemplates(DB *sql.DB, ctx context.Context, user_id int, id int) ([]models.Template, error) {
+	rows, err := DB.QueryContext(ctx, `SELECT id, name, created_at, updated_at FROM templates
+		WHERE user_id = $1 AND ($2 = 0 OR id = $2) ORDER BY name`, user_id, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	templates := []models.Template{}
+	by_id := map[int]int{}
+
+	for rows.Next() {
+		template := models.Template{Tasks: []models.TemplateTask{}}
+
+		if err := rows.Scan(
+			&template.ID,
+			&template.Name,
+			&template.Created_at,
+			&template.Updated_at,
+		); err != nil {
+			return nil, err
+		}
+
+		by_id[template.ID] = len(templates)
+		templates = append(templates, template)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	task_rows, err := DB.QueryContext(ctx, `SELECT tt.template_id, tt.title, tt.category, tt.priority, tt.due_offset
+		FROM template_tasks tt JOIN templates t ON t.id = tt.template_id
+		WHERE t.user_id = $1 AND ($2 = 0 OR t.id = $2) ORDER BY tt.template_id, tt.position`, user_id, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer task_rows.Close()
+
+	for task_rows.Next() {
+		var template_id int
+		var task models.TemplateTask
+
+		if err := task_rows.Scan(
+			&template_id,
+			&task.Title,
+			&task.Category,
+			&task.Priority,
+			&task.Due_offset,
+		); err != nil {
+			return nil, err
+		}
+
+		if ind, ok := by_id[template_id]; ok {
+			templates[ind].Tasks = append(templates[ind].Tasks, task)
+		}
+	}
+	return templates, task_rows.Err()
+}
+
+func SelectTemplate(DB *sql.DB, ctx context.Context, user_id int, id int) (models.Template, error) {
+	templates, err := SelectTemplates(DB, ctx, user_id, id)
+	if err != nil {
+		return models.Template{}, err
+	}
+
+	if len(templates) == 0 {
+		return models.Template{}, sql.ErrNoRows
+	}
+
+	return templates[0], nil
+}
+
+func TemplateExists(DB *sql.DB, ctx context.Context, user_id int, name string) bool {
+	found := 0
+
+	row := DB.QueryRowContext(ctx, "SELECT 1 FROM templates WHERE user_id = $1 AND name = $2", user_id, name)
+
+	return row.Scan(&found) == nil
+}
+
+// UpdateTemplate stores the name of the template and replaces its tasks.
+func UpdateTemplate(DB *sql.DB, ctx context.Context, user_id int, template models.Template) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE templates SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE user_id = $2 AND id = $3",
+		template.Name, user_id, template.ID)
+	if err != nil {
+		return err
+	}
+
+	if rows_affected, _ := res.RowsAffected(); rows_affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM template_tasks WHERE template_id = $1", template.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertTemplateTasks(tx, ctx, template.ID, template.Tasks)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func RemoveTemplate(DB *sql.DB, ctx context.Context, user_id int, id int) (int64, error) {
+	res, err := DB.ExecContext(ctx, "DELETE FROM templates WHERE user_id = $1 AND id = $2", user_id, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// SelectTitlesLike returns the user's task titles starting with any of the
+// prefixes, to find free titles without a query per candidate.
+func SelectTitlesLike(DB *sql.DB, ctx context.Context, user_id int, prefixes []string) (map[string]bool, error) {
+	rows, err := DB.QueryContext(ctx, `SELECT title FROM tasks WHERE user_id = $1
+		AND EXISTS (SELECT 1 FROM unnest($2::text[]) p WHERE left(title, length(p)) = p)`, user_id, pq.Array(prefixes))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	titles := map[string]bool{}
+
+	for rows.Next() {
+		var title string
+
+		if err := rows.Scan(&title); err != nil {
+			return nil, err
+		}
+		titles[title] = true
+	}
+	return titles, rows.Err()
+}
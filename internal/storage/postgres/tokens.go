@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"todo/internal/models"
+
+	"github.com/lib/pq"
+)
+
+func CreateAccessToken(DB *sql.DB, ctx context.Context, user_id int, name string, scopes []string, expires_at *time.Time, token_hash string) (models.AccessToken, error) {
+	access_token := models.AccessToken{Name: name, Scopes: scopes}
+
+	row := DB.QueryRowContext(ctx, `INSERT INTO access_tokens (user_id, name, scopes, expires_at, token_hash) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, expires_at`,
+		user_id, name, pq.Array(scopes), expires_at, token_hash)
+
+	err := row.Scan(&access_token.ID, &access_token.Created_at, &access_token.Expires_at)
+
+	return access_token, err
+}
+
+func SelectAccessTokens(DB *sql.DB, ctx context.Context, user_id int) ([]models.AccessToken, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT id, name, scopes, created_at, expires_at, last_used_at FROM access_tokens WHERE user_id = $1 ORDER BY id", user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	access_tokens := []models.AccessToken{}
+
+	for rows.Next() {
+		var access_token models.AccessToken
+
+		if err := rows.Scan(
+			&access_token.ID,
+			&access_token.Name,
+			pq.Array(&access_token.Scopes),
+			&access_token.Created_at,
+			&access_token.Expires_at,
+			&access_token.Last_used_at,
+		); err != nil {
+			return nil, err
+		}
+		access_tokens = append(access_tokens, access_token)
+	}
+	return access_tokens, rows.Err()
+}
+
+func RemoveAccessToken(DB *sql.DB, ctx context.Context, user_id int, id int) (int64, error) {
+	res, err := DB.ExecContext(ctx, "DELETE FROM access_tokens WHERE user_id = $1 AND id = $2", user_id, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// AuthenticateAccessToken resolves the user and scopes of an unexpired token
+// and records its use in the same statement. It also reports whether the
+// user's email is verified, for the unverified policy.
+func AuthenticateAccessToken(DB *sql.DB, ctx context.Context, token_hash string) (int, []string, bool, error) {
+	var user_id int
+	var scopes []string
+	var verified bool
+
+	row := DB.QueryRowContext(ctx, `UPDATE access_tokens a SET last_used_at = CURRENT_TIMESTAMP
+		FROM users u WHERE a.user_id = u.id AND a.token_hash = $1
+		AND (a.expires_at IS NULL OR a.expires_at > CURRENT_TIMESTAMP)
+		RETURNING a.user_id, a.scopes, u.email_verified_at IS NOT NULL`, token_hash)
+
+	err := row.Scan(&user_id, pq.Array(&scopes), &verified)
+
+	return user_id, scopes, verified, err
+}
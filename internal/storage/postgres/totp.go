@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+)
+
+// GetTOTPSecret returns the secret of a user with two-factor enabled, and an
+// empty one otherwise.
+func GetTOTPSecret(DB *sql.DB, ctx context.Context, user_id int) (string, error) {
+	var secret sql.NullString
+
+	row := DB.QueryRowContext(ctx, "SELECT totp_secret FROM users WHERE id = $1 AND totp_enabled_at IS NOT NULL", user_id)
+
+	err := row.Scan(&secret)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return secret.String, err
+}
+
+// EnableTOTP sets the secret and replaces the recovery codes at once, so
+// that two-factor is never on without a way back in.
+func EnableTOTP(DB *sql.DB, ctx context.Context, user_id int, secret string, code_hashes []string) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE users SET totp_secret = $1, totp_enabled_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND totp_enabled_at IS NULL`, secret, user_id)
+	if err != nil {
+		return err
+	}
+
+	if rows_affected, err := res.RowsAffected(); err != nil || rows_affected == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", user_id); err != nil {
+		return err
+	}
+
+	for _, code_hash := range code_hashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", user_id, code_hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func DisableTOTP(DB *sql.DB, ctx context.Context, user_id int) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1", user_id); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", user_id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks the recovery code used, reporting false when it was
+// unknown or already used.
+func UseRecoveryCode(DB *sql.DB, ctx context.Context, user_id int, code_hash string) (bool, error) {
+	res, err := DB.ExecContext(ctx, "UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL", user_id, code_hash)
+	if err != nil {
+		return false, err
+	}
+
+	rows_affected, err := res.RowsAffected()
+
+	return rows_affected > 0, err
+}
+
+func CountRecoveryCodes(DB *sql.DB, ctx context.Context, user_id int) (int, error) {
+	var count int
+
+	row := DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL", user_id)
+	err := row.Scan(&count)
+
+	return count, err
+}
@@ -0,0 +1,101 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"todo/internal/models"
+	"todo/internal/utils/task"
+)
+
+const importQuery = "INSERT INTO tasks (user_id, title, due_date, priority, category, completed, completed_at, todotxt_extras) values ($1, $2, $3, $4, $5, $6, CASE WHEN $6 THEN CURRENT_TIMESTAMP END, $7)"
+
+// InsertImportedTask adds a task in the first open or done status, unless
+// that status is at its WIP limit.
+func InsertImportedTask(DB *sql.DB, ctx context.Context, user_id int, row models.ImportTask) error {
+	return InsertImportedTasks(DB, ctx, user_id, []models.ImportTask{row})
+}
+
+// InsertImportedTasks adds all the tasks or none, also when they would take
+// the first open or done status beyond its WIP limit.
+func InsertImportedTasks(DB *sql.DB, ctx context.Context, user_id int, rows []models.ImportTask) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	completed := 0
+
+	for _, row := range rows {
+		if row.Completed {
+			completed++
+		}
+	}
+
+	if err := checkInsertWIP(tx, ctx, user_id, false, len(rows)-completed); err != nil {
+		return err
+	}
+
+	if err := checkInsertWIP(tx, ctx, user_id, true, completed); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		task_utils.TrimSpace(&row.Task)
+
+		_, err := tx.ExecContext(ctx, importQuery,
+			user_id,
+			row.Task.Title,
+			row.Task.Due_date,
+			row.Task.Priority,
+			row.Task.Category,
+			row.Completed,
+			row.Extras,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// StreamTodoTxtTasks is StreamTasks along with the todo.txt tokens kept from
+// an earlier import and the completion time, which tasks completed before it
+// was recorded lack.
+func StreamTodoTxtTasks(DB *sql.DB, ctx context.Context, query_params string, args []any, fn func(models.Task, string, string) error) error {
+	query := "SELECT title, completed, due_date, created_at, updated_at, priority, category, todotxt_extras, COALESCE(completed_at, updated_at) FROM tasks" + query_params
+
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var task models.Task
+		var extras string
+		var completed_at string
+
+		if err := rows.Scan(
+			&task.Title,
+			&task.Completed,
+			&task.Due_date,
+			&task.Created_at,
+			&task.Updated_at,
+			&task.Priority,
+			&task.Category,
+			&extras,
+			&completed_at,
+		); err != nil {
+			return err
+		}
+
+		if err := fn(task, extras, completed_at); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -3,27 +3,57 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"slices"
+	"strconv"
 	"time"
 	"todo/internal/models"
+	"todo/internal/utils/token"
 	
 	"github.com/redis/go-redis/v9"
 )
 
-func StoreSession(client *redis.Client, ctx context.Context, session_uuid string, user_id int, ip string, ua string) error {
+const sessionTTL = time.Hour
+
+// Each user has a sorted set of their session ids, scored by expiry, so that
+// their sessions can be listed and revoked. Expired members are pruned when
+// the set is read.
+func sessionIndexKey(user_id int) string {
+	return "user_sessions:" + strconv.Itoa(user_id)
+}
+
+func StoreSession(client *redis.Client, ctx context.Context, session_uuid string, user_id int, ip string, ua string, verified bool) error {
 	var session models.Session
 
+	csrf_token, err := token.Generate(32)
+	if err != nil {
+		return err
+	}
+
 	session.UID = user_id
 	session.IAT = time.Now().Unix()
-	session.EXP = time.Now().Add(time.Hour).Unix()
+	session.EXP = time.Now().Add(sessionTTL).Unix()
+	session.Last_seen = session.IAT
 	session.IP = ip
 	session.UA = ua
+	session.Verified = verified
+	session.CSRF = csrf_token
 
+	return writeSession(client, ctx, session_uuid, session)
+}
+
+func writeSession(client *redis.Client, ctx context.Context, session_uuid string, session models.Session) error {
 	val, err := json.Marshal(session)
 	if err != nil {
 		return err
 	}
 
-	err = client.Set(ctx, "session:"+session_uuid, val, time.Hour).Err()
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, "session:"+session_uuid, val, time.Until(time.Unix(session.EXP, 0)))
+		pipe.ZAdd(ctx, sessionIndexKey(session.UID), redis.Z{Score: float64(session.EXP), Member: session_uuid})
+		// No session outlives the last one written
+		pipe.Expire(ctx, sessionIndexKey(session.UID), sessionTTL)
+		return nil
+	})
 
 	return err
 }
@@ -43,10 +73,436 @@ func GetSession(client *redis.Client, ctx context.Context, session_uuid string)
 
 func GetDeleteSession(client *redis.Client, ctx context.Context, session_uuid string) (string, error) {
 	res, err := client.GetDel(ctx, "session:"+session_uuid).Result()
-	return res, err
+	if err != nil {
+		return res, err
+	}
+
+	var session models.Session
+
+	if err := json.Unmarshal([]byte(res), &session); err == nil {
+		err = client.ZRem(ctx, sessionIndexKey(session.UID), session_uuid).Err()
+		if err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
+// RenewSession extends the session by another sessionTTL. A session deleted
+// since it was read stays deleted, and keeps out of the index.
+func RenewSession(client *redis.Client, ctx context.Context, session_uuid string, session models.Session) error {
+	session.EXP = time.Now().Add(sessionTTL).Unix()
+	session.Last_seen = time.Now().Unix()
+
+	val, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	renewed, err := client.SetXX(ctx, "session:"+session_uuid, val, sessionTTL).Result()
+	if err != nil || !renewed {
+		return err
+	}
+
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, sessionIndexKey(session.UID), redis.Z{Score: float64(session.EXP), Member: session_uuid})
+		pipe.Expire(ctx, sessionIndexKey(session.UID), sessionTTL)
+		return nil
+	})
+
+	return err
+}
+
+// TouchSession records that the session was just used, without extending it.
+func TouchSession(client *redis.Client, ctx context.Context, session_uuid string, session models.Session) error {
+	session.Last_seen = time.Now().Unix()
+
+	val, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	return client.SetXX(ctx, "session:"+session_uuid, val, redis.KeepTTL).Err()
+}
+
+// SetSessionCSRF gives a CSRF token to a session from before they had one.
+func SetSessionCSRF(client *redis.Client, ctx context.Context, session_uuid string, session models.Session) error {
+	val, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	return client.SetXX(ctx, "session:"+session_uuid, val, redis.KeepTTL).Err()
+}
+
+// GetUserSessions returns the live sessions of a user by id, dropping index
+// members whose session expired or was deleted.
+func GetUserSessions(client *redis.Client, ctx context.Context, user_id int) (map[string]models.Session, error) {
+	sessions := map[string]models.Session{}
+
+	index_key := sessionIndexKey(user_id)
+
+	err := client.ZRemRangeByScore(ctx, index_key, "-inf", strconv.FormatInt(time.Now().Unix(), 10)).Err()
+	if err != nil {
+		return nil, err
+	}
+
+	session_uuids, err := client.ZRange(ctx, index_key, 0, -1).Result()
+	if err != nil || len(session_uuids) == 0 {
+		return sessions, err
+	}
+
+	keys := make([]string, len(session_uuids))
+
+	for i, session_uuid := range session_uuids {
+		keys[i] = "session:" + session_uuid
+	}
+
+	values, err := client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []any
+
+	for i, value := range values {
+		res, ok := value.(string)
+		if !ok {
+			stale = append(stale, session_uuids[i])
+			continue
+		}
+
+		var session models.Session
+
+		if err := json.Unmarshal([]byte(res), &session); err != nil || session.UID != user_id {
+			stale = append(stale, session_uuids[i])
+			continue
+		}
+
+		sessions[session_uuids[i]] = session
+	}
+
+	if len(stale) > 0 {
+		err = client.ZRem(ctx, index_key, stale...).Err()
+	}
+
+	return sessions, err
 }
 
-func RenewSession(client *redis.Client, ctx context.Context, session_uuid string) error {
-	err := client.Expire(ctx, "session:"+session_uuid, time.Hour).Err()
+func DeleteSession(client *redis.Client, ctx context.Context, user_id int, session_uuid string) error {
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, "session:"+session_uuid)
+		pipe.ZRem(ctx, sessionIndexKey(user_id), session_uuid)
+		return nil
+	})
+
 	return err
 }
+
+// DeleteUserSessions revokes every session of the user except the ones in
+// keep.
+func DeleteUserSessions(client *redis.Client, ctx context.Context, user_id int, keep ...string) (int, error) {
+	sessions, err := GetUserSessions(client, ctx, user_id)
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []string
+	var members []any
+
+	for session_uuid := range sessions {
+		if slices.Contains(keep, session_uuid) {
+			continue
+		}
+
+		keys = append(keys, "session:"+session_uuid)
+		members = append(members, session_uuid)
+	}
+
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, keys...)
+		pipe.ZRem(ctx, sessionIndexKey(user_id), members...)
+		return nil
+	})
+
+	return len(keys), err
+}
+
+func StoreStats(client *redis.Client, ctx context.Context, key string, stats models.Stats, ttl time.Duration) error {
+	val, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	return client.Set(ctx, "stats:"+key, val, ttl).Err()
+}
+
+func GetStats(client *redis.Client, ctx context.Context, key string) (models.Stats, error) {
+	var stats models.Stats
+
+	res, err := client.Get(ctx, "stats:"+key).Result()
+	if err != nil {
+		return stats, err
+	}
+
+	err = json.Unmarshal([]byte(res), &stats)
+
+	return stats, err
+}
+
+// Reset tokens are stored by hash, and read with GETDEL so that each can be
+// used once.
+func StoreResetToken(client *redis.Client, ctx context.Context, token_hash string, user_id int, ttl time.Duration) error {
+	return client.Set(ctx, "reset:"+token_hash, user_id, ttl).Err()
+}
+
+func GetDeleteResetToken(client *redis.Client, ctx context.Context, token_hash string) (int, error) {
+	return client.GetDel(ctx, "reset:"+token_hash).Int()
+}
+
+func StoreVerifyToken(client *redis.Client, ctx context.Context, token_hash string, user_id int, ttl time.Duration) error {
+	return client.Set(ctx, "verify:"+token_hash, user_id, ttl).Err()
+}
+
+func GetDeleteVerifyToken(client *redis.Client, ctx context.Context, token_hash string) (int, error) {
+	return client.GetDel(ctx, "verify:"+token_hash).Int()
+}
+
+// MarkSessionsVerified updates the sessions a user already has, so that
+// verifying the email lifts the unverified policy without logging in again.
+func MarkSessionsVerified(client *redis.Client, ctx context.Context, user_id int) error {
+	sessions, err := GetUserSessions(client, ctx, user_id)
+	if err != nil {
+		return err
+	}
+
+	for session_uuid, session := range sessions {
+		session.Verified = true
+
+		val, err := json.Marshal(session)
+		if err != nil {
+			return err
+		}
+
+		err = client.SetXX(ctx, "session:"+session_uuid, val, redis.KeepTTL).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AllowVerifyResend lets one resend through per interval. Otherwise it
+// returns how long until the next one is allowed.
+func AllowVerifyResend(client *redis.Client, ctx context.Context, user_id int, interval time.Duration) (bool, time.Duration, error) {
+	key := "verify_resend:" + strconv.Itoa(user_id)
+
+	ok, err := client.SetNX(ctx, key, 1, interval).Result()
+	if err != nil || ok {
+		return ok, 0, err
+	}
+
+	ttl, err := client.TTL(ctx, key).Result()
+
+	return false, ttl, err
+}
+
+// A TOTP secret waits here until a first code confirms the user set it up.
+func StoreTOTPEnrollment(client *redis.Client, ctx context.Context, user_id int, secret string, ttl time.Duration) error {
+	return client.Set(ctx, "totp_enroll:"+strconv.Itoa(user_id), secret, ttl).Err()
+}
+
+func GetTOTPEnrollment(client *redis.Client, ctx context.Context, user_id int) (string, error) {
+	return client.Get(ctx, "totp_enroll:"+strconv.Itoa(user_id)).Result()
+}
+
+func DeleteTOTPEnrollment(client *redis.Client, ctx context.Context, user_id int) error {
+	return client.Del(ctx, "totp_enroll:"+strconv.Itoa(user_id)).Err()
+}
+
+// UseTOTPStep records a period as used by the user, reporting false when its
+// code was already used.
+func UseTOTPStep(client *redis.Client, ctx context.Context, user_id int, step int64, ttl time.Duration) (bool, error) {
+	return client.SetNX(ctx, "totp_used:"+strconv.Itoa(user_id)+":"+strconv.FormatInt(step, 10), 1, ttl).Result()
+}
+
+// A pending login is a correct password still waiting for its second factor.
+func StorePendingLogin(client *redis.Client, ctx context.Context, token_hash string, user_id int, ttl time.Duration) error {
+	return client.Set(ctx, "2fa:"+token_hash, user_id, ttl).Err()
+}
+
+func GetPendingLogin(client *redis.Client, ctx context.Context, token_hash string) (int, error) {
+	return client.Get(ctx, "2fa:"+token_hash).Int()
+}
+
+func DeletePendingLogin(client *redis.Client, ctx context.Context, token_hash string) error {
+	return client.Del(ctx, "2fa:"+token_hash, "2fa_failures:"+token_hash).Err()
+}
+
+// CountPendingLoginFailure returns the number of wrong codes given so far for
+// the pending login.
+func CountPendingLoginFailure(client *redis.Client, ctx context.Context, token_hash string, ttl time.Duration) (int64, error) {
+	key := "2fa_failures:" + token_hash
+
+	failures, err := client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return failures, client.Expire(ctx, key, ttl).Err()
+}
+
+// Login throttling keeps a failure count and a lock per key, where a key is
+// an account or an IP.
+
+// LoginLockout returns how long the longest lock on the keys still lasts.
+func LoginLockout(client *redis.Client, ctx context.Context, keys ...string) (time.Duration, error) {
+	var lockout time.Duration
+
+	for _, key := range keys {
+		ttl, err := client.PTTL(ctx, "login_lock:"+key).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		lockout = max(lockout, ttl)
+	}
+
+	return lockout, nil
+}
+
+// RecordLoginFailure counts a failure within window. Past free failures, the
+// key is locked for a second, doubled with each further failure up to
+// max_lock. It returns the lock set, if any.
+func RecordLoginFailure(client *redis.Client, ctx context.Context, key string, window time.Duration, free int64, max_lock time.Duration) (time.Duration, error) {
+	failures, err := client.Incr(ctx, "login_fail:"+key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	err = client.Expire(ctx, "login_fail:"+key, window).Err()
+	if err != nil || failures <= free {
+		return 0, err
+	}
+
+	lock := max_lock
+	if exponent := failures - free - 1; exponent < 32 {
+		lock = min(time.Second<<exponent, max_lock)
+	}
+
+	return lock, client.Set(ctx, "login_lock:"+key, failures, lock).Err()
+}
+
+func ClearLoginFailures(client *redis.Client, ctx context.Context, keys ...string) (int64, error) {
+	var redis_keys []string
+
+	for _, key := range keys {
+		redis_keys = append(redis_keys, "login_fail:"+key, "login_lock:"+key)
+	}
+
+	return client.Del(ctx, redis_keys...).Result()
+}
+
+// takeToken is a token bucket holding up to capacity tokens, refilled at
+// capacity per window. It runs as a script so that instances sharing the
+// bucket can't race, and reads the time from redis so that their clocks
+// don't matter.
+var takeToken = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local window_ms = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now_ms = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now_ms
+
+tokens = math.min(capacity, tokens + math.max(0, now_ms - ts) * capacity / window_ms)
+
+local allowed = 0
+local retry_ms = 0
+
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry_ms = math.ceil((1 - tokens) * window_ms / capacity)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now_ms)
+redis.call('PEXPIRE', KEYS[1], window_ms)
+
+local reset_ms = math.ceil((capacity - tokens) * window_ms / capacity)
+
+return {allowed, math.floor(tokens), retry_ms, reset_ms}
+`)
+
+func TakeToken(client *redis.Client, ctx context.Context, key string, capacity int, window time.Duration) (models.RateLimit, error) {
+	var limit models.RateLimit
+
+	res, err := takeToken.Run(ctx, client, []string{"ratelimit:" + key}, capacity, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return limit, err
+	}
+
+	limit.Allowed = res[0] == 1
+	limit.Remaining = int(res[1])
+	limit.Retry_after = time.Duration(res[2]) * time.Millisecond
+	limit.Reset = time.Duration(res[3]) * time.Millisecond
+
+	return limit, nil
+}
+
+// A change of email waits here until the new address confirms it.
+func StoreEmailChange(client *redis.Client, ctx context.Context, token_hash string, change models.EmailChange, ttl time.Duration) error {
+	val, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	return client.Set(ctx, "email_change:"+token_hash, val, ttl).Err()
+}
+
+func GetDeleteEmailChange(client *redis.Client, ctx context.Context, token_hash string) (models.EmailChange, error) {
+	var change models.EmailChange
+
+	res, err := client.GetDel(ctx, "email_change:"+token_hash).Result()
+	if err != nil {
+		return change, err
+	}
+
+	err = json.Unmarshal([]byte(res), &change)
+
+	return change, err
+}
+
+// An OpenID Connect login waits here, by the hash of its state, for the
+// provider to redirect back.
+func StoreOIDCLogin(client *redis.Client, ctx context.Context, state_hash string, login models.OIDCLogin, ttl time.Duration) error {
+	val, err := json.Marshal(login)
+	if err != nil {
+		return err
+	}
+
+	return client.Set(ctx, "oidc:"+state_hash, val, ttl).Err()
+}
+
+func GetDeleteOIDCLogin(client *redis.Client, ctx context.Context, state_hash string) (models.OIDCLogin, error) {
+	var login models.OIDCLogin
+
+	res, err := client.GetDel(ctx, "oidc:"+state_hash).Result()
+	if err != nil {
+		return login, err
+	}
+
+	err = json.Unmarshal([]byte(res), &login)
+
+	return login, err
+}
@@ -0,0 +1,220 @@
+package csv_utils
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"todo/internal/models"
+)
+
+var ExportHeader = []string{"title", "completed", "due", "created_at", "updated_at", "priority", "category"}
+
+func TaskRecord(task models.Task) []string {
+	return []string{
+		escapeFormula(task.Title),
+		fmt.Sprintf("%t", task.Completed),
+		task.Due_date,
+		task.Created_at,
+		task.Updated_at,
+		task.Priority,
+		escapeFormula(task.Category),
+	}
+}
+
+// Spreadsheets run cells starting with these as formulas
+const formulaPrefixes = "=+-@\t\r"
+
+// escapeFormula quotes a value a spreadsheet would take for a formula, with
+// a leading ' that spreadsheets hide and imports strip again. Values already
+// starting with ' are quoted too, for the import to keep their own.
+func escapeFormula(val string) string {
+	if val != "" && strings.ContainsRune(formulaPrefixes+"'", rune(val[0])) {
+		return "'" + val
+	}
+
+	return val
+}
+
+// unescapeFormula strips the ' escapeFormula added. A ' followed by anything
+// else is part of the value.
+func unescapeFormula(val string) string {
+	if len(val) > 1 && val[0] == '\'' && strings.ContainsRune(formulaPrefixes+"'", rune(val[1])) {
+		return val[1:]
+	}
+
+	return val
+}
+
+// Column names accepted in the header row of an import file, keyed by the
+// lower-cased header and mapped to the NewTask field they fill.
+var headerAliases = map[string]string{
+	"title":     "title",
+	"name":      "title",
+	"task":      "title",
+	"due":       "due",
+	"due_date":  "due",
+	"deadline":  "due",
+	"priority":  "priority",
+	"category":  "category",
+	"list":      "category",
+	"completed": "completed",
+	"done":      "completed",
+}
+
+var importFields = []string{"title", "due", "priority", "category", "completed"}
+
+// ParseMapping parses a "field:Header,field:Header" override of the header
+// mapping passed in the map query param.
+func ParseMapping(param string) (map[string]string, error) {
+	mapping := map[string]string{}
+
+	if strings.TrimSpace(param) == "" {
+		return mapping, nil
+	}
+
+	for _, pair := range strings.Split(param, ",") {
+		field, header, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, errors.New("map param should be field:header pairs")
+		}
+
+		field = strings.ToLower(strings.TrimSpace(field))
+		header = strings.TrimSpace(header)
+
+		if !isImportField(field) {
+			return nil, fmt.Errorf("map param: unknown field %q", field)
+		}
+
+		mapping[field] = header
+	}
+
+	return mapping, nil
+}
+
+func isImportField(field string) bool {
+	for _, f := range importFields {
+		if f == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ReadTasks(r io.Reader, mapping map[string]string) ([]models.ImportTask, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("csv is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := mapColumns(header, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []models.ImportTask
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		if isBlank(record) {
+			continue
+		}
+
+		var task models.NewTask
+		var completed bool
+
+		for field, ind := range columns {
+			if ind >= len(record) {
+				continue
+			}
+
+			val := strings.TrimSpace(record[ind])
+
+			switch field {
+			case "title":
+				task.Title = unescapeFormula(val)
+			case "due":
+				task.Due_date = val
+			case "priority":
+				task.Priority = strings.ToLower(val)
+			case "category":
+				task.Category = unescapeFormula(val)
+			case "completed":
+				// Anything but a recognized true value is an open task
+				completed, _ = strconv.ParseBool(strings.ToLower(val))
+			}
+		}
+
+		rows = append(rows, models.ImportTask{Line: line, Task: task, Completed: completed})
+	}
+
+	return rows, nil
+}
+
+func mapColumns(header []string, mapping map[string]string) (map[string]int, error) {
+	columns := map[string]int{}
+
+	for ind, name := range header {
+		name = strings.TrimPrefix(name, "\ufeff")
+
+		for field, mapped := range mapping {
+			if strings.EqualFold(strings.TrimSpace(name), mapped) {
+				columns[field] = ind
+			}
+		}
+
+		field, ok := headerAliases[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			continue
+		}
+
+		if _, overridden := mapping[field]; overridden {
+			continue
+		}
+
+		if _, seen := columns[field]; !seen {
+			columns[field] = ind
+		}
+	}
+
+	for field, mapped := range mapping {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("mapped header %q not found", mapped)
+		}
+	}
+
+	if _, ok := columns["title"]; !ok {
+		return nil, errors.New("csv header lacks a title column")
+	}
+
+	return columns, nil
+}
+
+func isBlank(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,81 @@
+package csv_utils
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"todo/internal/models"
+)
+
+func TestEscapeFormula(t *testing.T) {
+	tests := []struct {
+		val  string
+		want string
+	}{
+		{"Buy milk", "Buy milk"},
+		{"", ""},
+		{"=HYPERLINK(\"x\")", "'=HYPERLINK(\"x\")"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"'quoted'", "''quoted'"},
+		{"a=b", "a=b"},
+	}
+
+	for _, test := range tests {
+		if got := escapeFormula(test.val); got != test.want {
+			t.Errorf("escapeFormula(%q) = %q, want %q", test.val, got, test.want)
+		}
+	}
+}
+
+func TestUnescapeFormula(t *testing.T) {
+	tests := []struct {
+		val  string
+		want string
+	}{
+		{"'=1", "=1"},
+		{"'\t=1", "\t=1"},
+		{"''quoted'", "'quoted'"},
+		{"'tis the season", "'tis the season"},
+		{"'", "'"},
+	}
+
+	for _, test := range tests {
+		if got := unescapeFormula(test.val); got != test.want {
+			t.Errorf("unescapeFormula(%q) = %q, want %q", test.val, got, test.want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	titles := []string{"Buy milk", "=1+1", "@home", "'tis the season", "'=1", "-", "a, \"b\""}
+
+	var buf bytes.Buffer
+
+	writer := csv.NewWriter(&buf)
+	writer.Write(ExportHeader)
+
+	for _, title := range titles {
+		writer.Write(TaskRecord(models.Task{Title: title, Completed: true, Priority: "low", Category: "+misc"}))
+	}
+
+	writer.Flush()
+
+	rows, err := ReadTasks(&buf, nil)
+	if err != nil {
+		t.Fatalf("ReadTasks: %v", err)
+	}
+
+	if len(rows) != len(titles) {
+		t.Fatalf("ReadTasks read %d rows, want %d", len(rows), len(titles))
+	}
+
+	for i, row := range rows {
+		if row.Task.Title != titles[i] || row.Task.Category != "+misc" || !row.Completed {
+			t.Errorf("row %d = %q in %q completed %t, want %q in %q completed", i, row.Task.Title, row.Task.Category, row.Completed, titles[i], "+misc")
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package dav_utils
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+const (
+	NSDAV    = "DAV:"
+	NSCalDAV = "urn:ietf:params:xml:ns:caldav"
+	NSCS     = "http://calendarserver.org/ns/"
+)
+
+var prefixes = map[string]string{
+	NSDAV:    "d",
+	NSCalDAV: "c",
+	NSCS:     "cs",
+}
+
+type anyElement struct {
+	XMLName xml.Name
+}
+
+type propElement struct {
+	Props []anyElement `xml:",any"`
+}
+
+type Propfind struct {
+	XMLName  xml.Name     `xml:"DAV: propfind"`
+	Prop     *propElement `xml:"DAV: prop"`
+	AllProp  *struct{}    `xml:"DAV: allprop"`
+	PropName *struct{}    `xml:"DAV: propname"`
+}
+
+type TimeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+type PropFilter struct {
+	Name         string    `xml:"name,attr"`
+	IsNotDefined *struct{} `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+}
+
+type CompFilter struct {
+	Name        string       `xml:"name,attr"`
+	CompFilters []CompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	PropFilters []PropFilter `xml:"urn:ietf:params:xml:ns:caldav prop-filter"`
+	TimeRange   *TimeRange   `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+}
+
+type Report struct {
+	XMLName xml.Name
+	Prop    *propElement `xml:"DAV: prop"`
+	Hrefs   []string     `xml:"DAV: href"`
+	Filter  *struct {
+		CompFilter CompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	} `xml:"urn:ietf:params:xml:ns:caldav filter"`
+}
+
+func (r Report) IsQuery() bool {
+	return r.XMLName.Space == NSCalDAV && r.XMLName.Local == "calendar-query"
+}
+
+func (r Report) IsMultiget() bool {
+	return r.XMLName.Space == NSCalDAV && r.XMLName.Local == "calendar-multiget"
+}
+
+// PropNames returns the requested properties, or nil when all properties
+// are requested.
+func (p Propfind) PropNames() []xml.Name {
+	if p.Prop == nil {
+		return nil
+	}
+
+	return names(p.Prop)
+}
+
+func (r Report) PropNames() []xml.Name {
+	if r.Prop == nil {
+		return nil
+	}
+
+	return names(r.Prop)
+}
+
+func names(prop *propElement) []xml.Name {
+	res := []xml.Name{}
+
+	for _, el := range prop.Props {
+		res = append(res, el.XMLName)
+	}
+
+	return res
+}
+
+func ParsePropfind(r io.Reader) (Propfind, error) {
+	var propfind Propfind
+
+	err := xml.NewDecoder(r).Decode(&propfind)
+	if err == io.EOF {
+		// An empty body is an allprop request
+		return Propfind{AllProp: &struct{}{}}, nil
+	}
+
+	return propfind, err
+}
+
+func ParseReport(r io.Reader) (Report, error) {
+	var report Report
+
+	err := xml.NewDecoder(r).Decode(&report)
+	if err == io.EOF {
+		return report, errors.New("report body is empty")
+	}
+
+	return report, err
+}
+
+type Prop struct {
+	Name  xml.Name
+	Inner string
+}
+
+type Response struct {
+	Href     string
+	Found    []Prop
+	NotFound []xml.Name
+	Status   int
+}
+
+type Multistatus struct {
+	Responses []Response
+}
+
+func (m *Multistatus) Add(res Response) {
+	m.Responses = append(m.Responses, res)
+}
+
+func (m *Multistatus) Encode() string {
+	var b strings.Builder
+
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/">`)
+
+	for _, res := range m.Responses {
+		b.WriteString("<d:response><d:href>")
+		b.WriteString(Escape(res.Href))
+		b.WriteString("</d:href>")
+
+		if res.Status != 0 {
+			b.WriteString("<d:status>" + statusLine(res.Status) + "</d:status>")
+		}
+
+		if len(res.Found) > 0 {
+			b.WriteString("<d:propstat><d:prop>")
+			for _, prop := range res.Found {
+				writeElement(&b, prop.Name, prop.Inner)
+			}
+			b.WriteString("</d:prop><d:status>" + statusLine(200) + "</d:status></d:propstat>")
+		}
+
+		if len(res.NotFound) > 0 {
+			b.WriteString("<d:propstat><d:prop>")
+			for _, name := range res.NotFound {
+				writeElement(&b, name, "")
+			}
+			b.WriteString("</d:prop><d:status>" + statusLine(404) + "</d:status></d:propstat>")
+		}
+
+		b.WriteString("</d:response>")
+	}
+
+	b.WriteString("</d:multistatus>")
+
+	return b.String()
+}
+
+func writeElement(b *strings.Builder, name xml.Name, inner string) {
+	tag := name.Local
+	attr := ""
+
+	if prefix, ok := prefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+	} else if name.Space != "" {
+		tag = "x:" + name.Local
+		attr = ` xmlns:x="` + Escape(name.Space) + `"`
+	}
+
+	if inner == "" {
+		b.WriteString("<" + tag + attr + "/>")
+		return
+	}
+
+	b.WriteString("<" + tag + attr + ">" + inner + "</" + tag + ">")
+}
+
+func statusLine(code int) string {
+	switch code {
+	case 200:
+		return "HTTP/1.1 200 OK"
+	case 404:
+		return "HTTP/1.1 404 Not Found"
+	default:
+		return "HTTP/1.1 500 Internal Server Error"
+	}
+}
+
+func Escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func Href(href string) string {
+	return "<d:href>" + Escape(href) + "</d:href>"
+}
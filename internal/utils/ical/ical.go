@@ -0,0 +1,158 @@
+package ical_utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"todo/internal/models"
+)
+
+const ProdID = "-//todo//tasks//EN"
+
+const utcLayout = "20060102T150405Z"
+
+// RFC 5545 priorities: 1-4 high, 5 medium, 6-9 low, 0 undefined.
+var priorities = map[string]int{
+	"high":   1,
+	"medium": 5,
+	"low":    9,
+}
+
+func PriorityToICal(priority string) int {
+	return priorities[priority]
+}
+
+func UID(task_id string) string {
+	return task_id + "@todo"
+}
+
+func WriteCalendar(w io.Writer, name string, tasks []models.DBtask, with_events bool) error {
+	buf := bufio.NewWriter(w)
+
+	writeLine(buf, "BEGIN:VCALENDAR")
+	writeLine(buf, "VERSION:2.0")
+	writeLine(buf, "PRODID:"+ProdID)
+	writeLine(buf, "CALSCALE:GREGORIAN")
+	writeLine(buf, "X-WR-CALNAME:"+EscapeText(name))
+
+	for _, task := range tasks {
+		WriteTodo(buf, UID(task.ID), task)
+
+		if with_events {
+			writeEvent(buf, task)
+		}
+	}
+
+	writeLine(buf, "END:VCALENDAR")
+
+	return buf.Flush()
+}
+
+// WriteTodoCalendar writes a calendar object resource holding a single task.
+func WriteTodoCalendar(w io.Writer, uid string, task models.DBtask) error {
+	buf := bufio.NewWriter(w)
+
+	writeLine(buf, "BEGIN:VCALENDAR")
+	writeLine(buf, "VERSION:2.0")
+	writeLine(buf, "PRODID:"+ProdID)
+	WriteTodo(buf, uid, task)
+	writeLine(buf, "END:VCALENDAR")
+
+	return buf.Flush()
+}
+
+func WriteTodo(buf *bufio.Writer, uid string, task models.DBtask) {
+	writeLine(buf, "BEGIN:VTODO")
+	writeLine(buf, "UID:"+EscapeText(uid))
+	writeLine(buf, "DTSTAMP:"+FormatTime(task.Updated_at))
+	writeLine(buf, "CREATED:"+FormatTime(task.Created_at))
+	writeLine(buf, "LAST-MODIFIED:"+FormatTime(task.Updated_at))
+	writeLine(buf, "SUMMARY:"+EscapeText(task.Title))
+	writeLine(buf, "DUE:"+FormatTime(task.Due_date))
+	writeLine(buf, fmt.Sprintf("PRIORITY:%d", PriorityToICal(task.Priority)))
+	writeLine(buf, "CATEGORIES:"+EscapeText(task.Category))
+
+	if task.Completed {
+		completed_at := task.Updated_at
+		if task.Completed_at != nil {
+			completed_at = *task.Completed_at
+		}
+
+		writeLine(buf, "STATUS:COMPLETED")
+		writeLine(buf, "COMPLETED:"+FormatTime(completed_at))
+		writeLine(buf, "PERCENT-COMPLETE:100")
+	} else {
+		writeLine(buf, "STATUS:NEEDS-ACTION")
+	}
+
+	writeLine(buf, "END:VTODO")
+}
+
+func writeEvent(buf *bufio.Writer, task models.DBtask) {
+	writeLine(buf, "BEGIN:VEVENT")
+	writeLine(buf, "UID:"+task.ID+"-due@todo")
+	writeLine(buf, "DTSTAMP:"+FormatTime(task.Updated_at))
+	writeLine(buf, "DTSTART:"+FormatTime(task.Due_date))
+	writeLine(buf, "DURATION:PT30M")
+	writeLine(buf, "SUMMARY:"+EscapeText("Due: "+task.Title))
+	writeLine(buf, "CATEGORIES:"+EscapeText(task.Category))
+	writeLine(buf, "TRANSP:TRANSPARENT")
+
+	if task.Completed {
+		writeLine(buf, "STATUS:CANCELLED")
+	} else {
+		writeLine(buf, "STATUS:CONFIRMED")
+	}
+
+	writeLine(buf, "END:VEVENT")
+}
+
+// FormatTime converts a timestamp as returned by the database into an
+// iCalendar UTC date-time.
+func FormatTime(timestamp string) string {
+	date, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return timestamp
+	}
+
+	return date.UTC().Format(utcLayout)
+}
+
+func EscapeText(s string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	)
+
+	return replacer.Replace(s)
+}
+
+// Content lines longer than 75 octets are folded with CRLF followed by a
+// space, without splitting a multi-byte character.
+func writeLine(buf *bufio.Writer, line string) {
+	limit := 75
+
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(line[cut]) {
+			cut--
+		}
+
+		buf.WriteString(line[:cut])
+		buf.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -0,0 +1,130 @@
+package ical_utils
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"todo/internal/models"
+)
+
+func writeTodo(task models.DBtask) string {
+	var buf bytes.Buffer
+
+	writer := bufio.NewWriter(&buf)
+	WriteTodo(writer, UID(task.ID), task)
+	writer.Flush()
+
+	return buf.String()
+}
+
+func TestWriteTodoCompleted(t *testing.T) {
+	completed_at := "2026-10-20T08:30:00Z"
+
+	tests := []struct {
+		name string
+		task models.DBtask
+		want string
+	}{
+		{"completion time", models.DBtask{Completed: true, Updated_at: "2026-10-21T12:00:00Z", Completed_at: &completed_at}, "COMPLETED:20261020T083000Z\r\n"},
+		{"no completion time", models.DBtask{Completed: true, Updated_at: "2026-10-21T12:00:00Z"}, "COMPLETED:20261021T120000Z\r\n"},
+		{"open", models.DBtask{Updated_at: "2026-10-21T12:00:00Z"}, "STATUS:NEEDS-ACTION\r\n"},
+	}
+
+	for _, test := range tests {
+		if got := writeTodo(test.task); !strings.Contains(got, test.want) {
+			t.Errorf("%s: WriteTodo wrote %q, want it to contain %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"plain", "plain"},
+		{"a, b; c", `a\, b\; c`},
+		{`back\slash`, `back\\slash`},
+		{"two\nlines", `two\nlines`},
+		{"two\r\nlines", `two\nlines`},
+	}
+
+	for _, test := range tests {
+		got := EscapeText(test.text)
+		if got != test.want {
+			t.Errorf("EscapeText(%q) = %q, want %q", test.text, got, test.want)
+		}
+
+		if back := UnescapeText(got); back != strings.ReplaceAll(test.text, "\r\n", "\n") {
+			t.Errorf("UnescapeText(%q) = %q, want %q", got, back, test.text)
+		}
+	}
+}
+
+func TestWriteLineFolding(t *testing.T) {
+	lines := []string{
+		"SUMMARY:" + strings.Repeat("a", 200),
+		"SUMMARY:" + strings.Repeat("é", 100),
+	}
+
+	for _, line := range lines {
+		var buf bytes.Buffer
+
+		writer := bufio.NewWriter(&buf)
+		writeLine(writer, line)
+		writer.Flush()
+
+		for _, folded := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+			if len(folded) > 75 {
+				t.Errorf("folded line of %d octets: %q", len(folded), folded)
+			}
+		}
+
+		unfolded, err := unfold(&buf)
+		if err != nil {
+			t.Fatalf("unfold: %v", err)
+		}
+
+		if len(unfolded) != 1 || unfolded[0] != line {
+			t.Errorf("unfold(writeLine(%q)) = %q", line, unfolded)
+		}
+	}
+}
+
+func TestParseTodoRoundTrip(t *testing.T) {
+	task := models.DBtask{
+		ID:         "3f0c",
+		Title:      "Call Ann, then Bob; urgently",
+		Completed:  true,
+		Due_date:   "2026-10-22T09:00:00Z",
+		Created_at: "2026-10-20T08:00:00Z",
+		Updated_at: "2026-10-21T08:00:00Z",
+		Priority:   "high",
+		Category:   "work",
+	}
+
+	var buf bytes.Buffer
+
+	if err := WriteTodoCalendar(&buf, UID(task.ID), task); err != nil {
+		t.Fatalf("WriteTodoCalendar: %v", err)
+	}
+
+	todo, err := ParseTodo(&buf, time.UTC)
+	if err != nil {
+		t.Fatalf("ParseTodo: %v", err)
+	}
+
+	if todo.UID != UID(task.ID) || todo.Summary != task.Title || !todo.Completed {
+		t.Errorf("ParseTodo = %+v, want uid %q summary %q completed", todo, UID(task.ID), task.Title)
+	}
+
+	if todo.Due == nil || !todo.Due.Equal(time.Date(2026, time.October, 22, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("ParseTodo due = %v, want 2026-10-22 09:00 UTC", todo.Due)
+	}
+
+	if PriorityFromICal(todo.Priority) != task.Priority || len(todo.Categories) != 1 || todo.Categories[0] != task.Category {
+		t.Errorf("ParseTodo priority %d categories %q, want %q and %q", todo.Priority, todo.Categories, task.Priority, task.Category)
+	}
+}
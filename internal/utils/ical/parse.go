@@ -0,0 +1,243 @@
+package ical_utils
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Todo struct {
+	UID        string
+	Summary    string
+	Due        *time.Time
+	DateOnly   bool
+	Priority   int
+	Categories []string
+	Completed  bool
+}
+
+type property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// ParseTodo reads the first VTODO of a calendar object resource. Only the
+// properties mapped onto the tasks table are kept. Dates and floating times
+// without a TZID are read in loc.
+func ParseTodo(r io.Reader, loc *time.Location) (Todo, error) {
+	var todo Todo
+
+	lines, err := unfold(r)
+	if err != nil {
+		return todo, err
+	}
+
+	in_todo, found, nested := false, false, 0
+
+	for _, line := range lines {
+		prop, err := parseProperty(line)
+		if err != nil {
+			return todo, err
+		}
+
+		switch {
+		case prop.Name == "BEGIN" && strings.EqualFold(prop.Value, "VTODO") && !found:
+			in_todo, found = true, true
+			continue
+		case prop.Name == "END" && strings.EqualFold(prop.Value, "VTODO") && in_todo && nested == 0:
+			in_todo = false
+			continue
+		}
+
+		if !in_todo {
+			continue
+		}
+
+		// VALARM and other sub-components of the VTODO are skipped
+		if prop.Name == "BEGIN" {
+			nested++
+			continue
+		}
+		if prop.Name == "END" {
+			nested--
+			continue
+		}
+		if nested > 0 {
+			continue
+		}
+
+		switch prop.Name {
+		case "UID":
+			todo.UID = prop.Value
+		case "SUMMARY":
+			todo.Summary = UnescapeText(prop.Value)
+		case "DUE":
+			due, date_only, err := parseDateTime(prop, loc)
+			if err != nil {
+				return todo, err
+			}
+			todo.Due, todo.DateOnly = &due, date_only
+		case "PRIORITY":
+			todo.Priority, _ = strconv.Atoi(prop.Value)
+		case "CATEGORIES":
+			for _, category := range splitText(prop.Value) {
+				if category = strings.TrimSpace(category); category != "" {
+					todo.Categories = append(todo.Categories, category)
+				}
+			}
+		case "STATUS":
+			if strings.EqualFold(prop.Value, "COMPLETED") {
+				todo.Completed = true
+			}
+		case "COMPLETED":
+			todo.Completed = true
+		case "PERCENT-COMPLETE":
+			if prop.Value == "100" {
+				todo.Completed = true
+			}
+		}
+	}
+
+	if !found {
+		return todo, errors.New("calendar object has no VTODO")
+	}
+
+	if in_todo {
+		return todo, errors.New("VTODO is not terminated")
+	}
+
+	return todo, nil
+}
+
+func PriorityFromICal(priority int) string {
+	switch {
+	case 1 <= priority && priority <= 4:
+		return "high"
+	case 6 <= priority && priority <= 9:
+		return "low"
+	default:
+		return "medium"
+	}
+}
+
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	var lines []string
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+
+		if line == "" {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+func parseProperty(line string) (property, error) {
+	prop := property{Params: map[string]string{}}
+
+	// The value starts at the first colon that is not inside a quoted parameter
+	quoted, colon := false, -1
+
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		}
+
+		if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+
+	if colon == -1 {
+		return prop, errors.New("malformed content line: " + line)
+	}
+
+	parts := strings.Split(line[:colon], ";")
+
+	prop.Name = strings.ToUpper(parts[0])
+	prop.Value = line[colon+1:]
+
+	for _, param := range parts[1:] {
+		key, val, _ := strings.Cut(param, "=")
+		prop.Params[strings.ToUpper(key)] = strings.Trim(val, `"`)
+	}
+
+	return prop, nil
+}
+
+func parseDateTime(prop property, loc *time.Location) (time.Time, bool, error) {
+	if prop.Params["VALUE"] == "DATE" || len(prop.Value) == 8 {
+		date, err := time.ParseInLocation("20060102", prop.Value, loc)
+		return date, true, err
+	}
+
+	if strings.HasSuffix(prop.Value, "Z") {
+		date, err := time.Parse(utcLayout, prop.Value)
+		return date, false, err
+	}
+
+	if tzid := prop.Params["TZID"]; tzid != "" {
+		if tz, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+			loc = tz
+		}
+	}
+
+	date, err := time.ParseInLocation("20060102T150405", prop.Value, loc)
+
+	return date, false, err
+}
+
+func UnescapeText(s string) string {
+	replacer := strings.NewReplacer(
+		`\\`, `\`,
+		`\;`, ";",
+		`\,`, ",",
+		`\n`, "\n",
+		`\N`, "\n",
+	)
+
+	return replacer.Replace(s)
+}
+
+// splitText splits a multi-valued text property on unescaped commas.
+func splitText(s string) []string {
+	var values []string
+	var current strings.Builder
+
+	escaped := false
+
+	for _, c := range s {
+		switch {
+		case escaped:
+			current.WriteRune('\\')
+			current.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == ',':
+			values = append(values, UnescapeText(current.String()))
+			current.Reset()
+		default:
+			current.WriteRune(c)
+		}
+	}
+
+	return append(values, UnescapeText(current.String()))
+}
@@ -1,14 +1,171 @@
 package password
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Hashes are stored in the PHC string format, which names the algorithm, its
+// version and parameters, so that older hashes keep verifying as these
+// change. Hashes from before Argon2id are bcrypt's own format.
+
+// Params are the Argon2id parameters of new hashes. Memory is in KiB.
+type Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultParams are the second recommended option of RFC 9106.
+var DefaultParams = Params{Memory: 64 * 1024, Time: 3, Threads: 4, SaltLen: 16, KeyLen: 32}
+
+var params = DefaultParams
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// Configure sets the parameters of new hashes, zero fields keeping their
+// default. Hashes with other parameters are reported by NeedsRehash.
+func Configure(memory uint32, time uint32, threads uint8) {
+	params = DefaultParams
+
+	if memory != 0 {
+		params.Memory = memory
+	}
+
+	if time != 0 {
+		params.Time = time
+	}
+
+	if threads != 0 {
+		params.Threads = threads
+	}
+}
+
 func Hash(password []byte) ([]byte, error) {
-	return bcrypt.GenerateFromPassword(password, 10)
+	salt := make([]byte, params.SaltLen)
+
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey(password, salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Time, params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))), nil
 }
 
 func IsCorrectPassword(hashed_password, inp_password []byte) bool {
-	err := bcrypt.CompareHashAndPassword(hashed_password, inp_password)
-	return err == nil
+	if !strings.HasPrefix(string(hashed_password), "$argon2id$") {
+		err := bcrypt.CompareHashAndPassword(hashed_password, inp_password)
+		return err == nil
+	}
+
+	hash_params, salt, key, err := decode(string(hashed_password))
+	if err != nil {
+		return false
+	}
+
+	inp_key := argon2.IDKey(inp_password, salt, hash_params.Time, hash_params.Memory, hash_params.Threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, inp_key) == 1
+}
+
+// NeedsRehash reports whether a hash uses another algorithm or other
+// parameters than new hashes do. It is meant to be checked once the
+// password was found correct, when it can be hashed again.
+func NeedsRehash(hashed_password []byte) bool {
+	hash_params, salt, key, err := decode(string(hashed_password))
+	if err != nil {
+		return true
+	}
+
+	hash_params.SaltLen = uint32(len(salt))
+	hash_params.KeyLen = uint32(len(key))
+
+	return hash_params != params
+}
+
+func decode(hashed_password string) (Params, []byte, []byte, error) {
+	var hash_params Params
+	var version int
+
+	// "", "argon2id", "v=19", "m=65536,t=3,p=4", salt, key
+	fields := strings.Split(hashed_password, "$")
+	if len(fields) != 6 || fields[1] != "argon2id" {
+		return hash_params, nil, nil, ErrInvalidHash
+	}
+
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return hash_params, nil, nil, ErrInvalidHash
+	}
+
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &hash_params.Memory, &hash_params.Time, &hash_params.Threads); err != nil {
+		return hash_params, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return hash_params, nil, nil, ErrInvalidHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil || len(key) == 0 {
+		return hash_params, nil, nil, ErrInvalidHash
+	}
+
+	return hash_params, salt, key, nil
+}
+
+var dummyHash = sync.OnceValue(func() []byte {
+	hashed_password, _ := Hash([]byte("not a password of anyone"))
+	return hashed_password
+})
+
+var legacyDummyHash = sync.OnceValue(func() []byte {
+	hashed_password, _ := bcrypt.GenerateFromPassword([]byte("not a password of anyone"), 10)
+	return hashed_password
+})
+
+// legacyPermille is the share of stored hashes still in bcrypt, in permille.
+var legacyPermille atomic.Int64
+
+// SetLegacyShare tells CompareDummy how many of the stored hashes are still
+// bcrypt, out of the total.
+func SetLegacyShare(legacy int, total int) {
+	if total <= 0 {
+		legacyPermille.Store(0)
+		return
+	}
+
+	legacyPermille.Store(int64(legacy) * 1000 / int64(total))
+}
+
+// CompareDummy takes as long as IsCorrectPassword, for logins of unknown
+// users to be answered in the same time. Bcrypt and Argon2id take very
+// different times, so each email is compared against the same one of them
+// every time, bcrypt for as large a share of emails as of the stored hashes.
+func CompareDummy(email string, inp_password []byte) {
+	sum := fnv.New64a()
+	sum.Write([]byte(strings.ToLower(email)))
+
+	if int64(sum.Sum64()%1000) < legacyPermille.Load() {
+		IsCorrectPassword(legacyDummyHash(), inp_password)
+		return
+	}
+
+	IsCorrectPassword(dummyHash(), inp_password)
 }
@@ -0,0 +1,95 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashRoundTrip(t *testing.T) {
+	t.Cleanup(func() { params = DefaultParams })
+
+	// Cheap parameters, the format is the same
+	Configure(8*1024, 1, 1)
+
+	hashed_password, err := Hash([]byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(hashed_password), "$argon2id$v=19$m=8192,t=1,p=1$") {
+		t.Errorf("Hash() = %s, not a PHC Argon2id string of the parameters", hashed_password)
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"correct horse", true},
+		{"correct horse ", false},
+		{"Correct horse", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := IsCorrectPassword(hashed_password, []byte(test.password)); got != test.want {
+			t.Errorf("IsCorrectPassword(%q) = %v, want %v", test.password, got, test.want)
+		}
+	}
+
+	if NeedsRehash(hashed_password) {
+		t.Errorf("NeedsRehash() of a hash with the current parameters = true")
+	}
+
+	Configure(8*1024, 2, 1)
+
+	if !NeedsRehash(hashed_password) {
+		t.Errorf("NeedsRehash() after the parameters changed = false")
+	}
+
+	// Older parameters keep verifying
+	if !IsCorrectPassword(hashed_password, []byte("correct horse")) {
+		t.Errorf("IsCorrectPassword() of a hash with older parameters = false")
+	}
+}
+
+func TestBcryptFallback(t *testing.T) {
+	hashed_password, err := bcrypt.GenerateFromPassword([]byte("correct horse"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsCorrectPassword(hashed_password, []byte("correct horse")) {
+		t.Errorf("IsCorrectPassword() of a bcrypt hash = false")
+	}
+
+	if IsCorrectPassword(hashed_password, []byte("wrong horse")) {
+		t.Errorf("IsCorrectPassword() of a bcrypt hash and a wrong password = true")
+	}
+
+	if !NeedsRehash(hashed_password) {
+		t.Errorf("NeedsRehash() of a bcrypt hash = false")
+	}
+}
+
+func TestInvalidHash(t *testing.T) {
+	tests := []string{
+		"",
+		"$argon2id$v=19$m=8192,t=1,p=1$c2FsdHNhbHQ",
+		"$argon2id$v=16$m=8192,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=8192,t=1,p=1$c2FsdHNhbHQ$",
+		"$argon2i$v=19$m=8192,t=1,p=1$c2FsdHNhbHQ$a2V5",
+	}
+
+	for _, test := range tests {
+		if IsCorrectPassword([]byte(test), []byte("correct horse")) {
+			t.Errorf("IsCorrectPassword(%q) = true", test)
+		}
+
+		if !NeedsRehash([]byte(test)) {
+			t.Errorf("NeedsRehash(%q) = false", test)
+		}
+	}
+}
@@ -0,0 +1,488 @@
+// Package quickadd turns a one-line task description such as
+// "Pay rent tomorrow 9am !high #finance" into a new task.
+//
+// Recognized tokens are removed from the title:
+//
+//   - priority: !high !medium !low, !h !m !l, !1 !2 !3, !!! and !!
+//   - category: #name, the first one wins
+//   - dates: today, tonight, tomorrow, eod, eow, <weekday>, next <weekday>,
+//     next week, next month, in N minutes/hours/days/weeks/months, 2026-10-25,
+//     Oct 25, 25 October
+//   - times: 9am, 9:30pm, 21:00, noon, midnight, optionally after "at"
+//
+// A bare weekday is its soonest occurrence including today, "next <weekday>"
+// is the soonest one after today. Short weekday names such as "sun" and "sat"
+// are common words, so they are only read as dates after "on", "next" or
+// "this". Relative amounts are capped at maxRelativeAmount. A date without a
+// time is due by the end of that day, and a time without a date is today, or
+// tomorrow once it passed.
+package quickadd
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"todo/internal/models"
+)
+
+const DefaultCategory = "inbox"
+
+const DefaultPriority = "medium"
+
+type Result struct {
+	Task         models.NewTask
+	Due          time.Time
+	DueDefaulted bool
+	Matches      []models.QuickMatch
+}
+
+var priorityTokens = map[string]string{
+	"!high": "high", "!h": "high", "!1": "high", "!!!": "high",
+	"!medium": "medium", "!med": "medium", "!m": "medium", "!2": "medium", "!!": "medium",
+	"!low": "low", "!l": "low", "!3": "low",
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+var shortWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday, "tues": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday, "thurs": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// weekday reads a weekday name, the short forms only when short is set.
+func weekday(word string, short bool) (time.Weekday, bool) {
+	if day, ok := weekdays[word]; ok {
+		return day, true
+	}
+
+	if day, ok := shortWeekdays[word]; ok && short {
+		return day, true
+	}
+
+	return 0, false
+}
+
+// The largest N of "in N days" and the like
+const maxRelativeAmount = 1000
+
+var months = map[string]time.Month{
+	"january": time.January, "jan": time.January,
+	"february": time.February, "feb": time.February,
+	"march": time.March, "mar": time.March,
+	"april": time.April, "apr": time.April,
+	"may":  time.May,
+	"june": time.June, "jun": time.June,
+	"july": time.July, "jul": time.July,
+	"august": time.August, "aug": time.August,
+	"september": time.September, "sep": time.September, "sept": time.September,
+	"october": time.October, "oct": time.October,
+	"november": time.November, "nov": time.November,
+	"december": time.December, "dec": time.December,
+}
+
+var (
+	isoDatePattern  = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	clockPattern    = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+	dayNumPattern   = regexp.MustCompile(`^(\d{1,2})(?:st|nd|rd|th)?$`)
+	durationPattern = regexp.MustCompile(`^(\d+)$`)
+)
+
+// Words that only introduce a date or time and are dropped along with it.
+var prepositions = map[string]bool{"at": true, "on": true, "by": true, "due": true}
+
+type parser struct {
+	now    time.Time
+	words  []string
+	lower  []string
+	used   []bool
+	result Result
+
+	date     *time.Time
+	clock    *time.Duration
+	instant  *time.Time
+	date_set bool
+}
+
+// Parse interprets text relative to now, whose location is the user's
+// timezone. The due date of the task is returned as RFC 3339 in UTC.
+func Parse(text string, now time.Time) Result {
+	p := &parser{now: now, words: strings.Fields(text)}
+
+	for _, word := range p.words {
+		p.lower = append(p.lower, strings.Trim(strings.ToLower(word), ",.;"))
+	}
+	p.used = make([]bool, len(p.words))
+
+	p.result.Task.Priority = DefaultPriority
+	p.result.Task.Category = DefaultCategory
+
+	category_set, priority_set := false, false
+
+	for i := 0; i < len(p.words); i++ {
+		word := p.lower[i]
+
+		if priority, ok := priorityTokens[word]; ok && !priority_set {
+			p.result.Task.Priority = priority
+			priority_set = true
+			p.consume(i, 1, "priority")
+			continue
+		}
+
+		if strings.HasPrefix(p.words[i], "#") && len(p.words[i]) > 1 && !category_set {
+			p.result.Task.Category = strings.TrimRight(p.words[i][1:], ",.;")
+			category_set = true
+			p.consume(i, 1, "category")
+			continue
+		}
+
+		if n := p.matchDate(i); n > 0 {
+			i += n - 1
+			continue
+		}
+
+		if n := p.matchTime(i); n > 0 {
+			i += n - 1
+			continue
+		}
+	}
+
+	var title []string
+
+	for i, word := range p.words {
+		if !p.used[i] {
+			title = append(title, word)
+		}
+	}
+
+	p.result.Task.Title = strings.Join(title, " ")
+	p.result.Due = p.due()
+	p.result.Task.Due_date = p.result.Due.UTC().Format(time.RFC3339)
+
+	return p.result
+}
+
+func (p *parser) consume(i int, n int, kind string) {
+	start := i
+
+	// Take a preposition in front of the phrase along with it
+	if i > 0 && !p.used[i-1] && prepositions[p.lower[i-1]] && kind != "priority" && kind != "category" {
+		start = i - 1
+	}
+
+	for j := start; j < i+n; j++ {
+		p.used[j] = true
+	}
+
+	p.result.Matches = append(p.result.Matches, models.QuickMatch{Text: strings.Join(p.words[start:i+n], " "), Kind: kind})
+}
+
+func (p *parser) word(i int) string {
+	if i < 0 || i >= len(p.lower) || p.used[i] {
+		return ""
+	}
+
+	return p.lower[i]
+}
+
+func (p *parser) setDate(date time.Time) {
+	date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, p.now.Location())
+	p.date = &date
+	p.date_set = true
+}
+
+func (p *parser) setClock(hour int, minute int) {
+	clock := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute
+	p.clock = &clock
+}
+
+func (p *parser) matchDate(i int) int {
+	if p.date_set {
+		return 0
+	}
+
+	word := p.word(i)
+
+	switch word {
+	case "today":
+		p.setDate(p.now)
+		p.consume(i, 1, "due")
+		return 1
+	case "tonight":
+		p.setDate(p.now)
+		p.setClock(20, 0)
+		p.consume(i, 1, "due")
+		return 1
+	case "tomorrow", "tmr", "tmrw":
+		p.setDate(p.now.AddDate(0, 0, 1))
+		p.consume(i, 1, "due")
+		return 1
+	case "eod":
+		p.setDate(p.now)
+		p.setClock(17, 0)
+		p.consume(i, 1, "due")
+		return 1
+	case "eow":
+		p.setDate(p.nextWeekday(time.Friday, true))
+		p.setClock(17, 0)
+		p.consume(i, 1, "due")
+		return 1
+	case "next", "this":
+		following := p.word(i + 1)
+
+		if day, ok := weekday(following, true); ok {
+			p.setDate(p.nextWeekday(day, word == "this"))
+			p.consume(i, 2, "due")
+			return 2
+		}
+
+		if word == "next" && following == "week" {
+			p.setDate(p.nextWeekday(time.Monday, false))
+			p.consume(i, 2, "due")
+			return 2
+		}
+
+		if word == "next" && following == "month" {
+			first := time.Date(p.now.Year(), p.now.Month()+1, 1, 0, 0, 0, 0, p.now.Location())
+			p.setDate(first)
+			p.consume(i, 2, "due")
+			return 2
+		}
+	case "in":
+		return p.matchRelative(i)
+	}
+
+	if day, ok := weekday(word, p.word(i-1) == "on"); ok {
+		p.setDate(p.nextWeekday(day, true))
+		p.consume(i, 1, "due")
+		return 1
+	}
+
+	if isoDatePattern.MatchString(word) {
+		date, err := time.ParseInLocation("2006-01-02", word, p.now.Location())
+		if err == nil {
+			p.setDate(date)
+			p.consume(i, 1, "due")
+			return 1
+		}
+	}
+
+	// "Oct 25" and "25 October", optionally followed by a year
+	if month, ok := months[word]; ok {
+		if day, ok := dayNumber(p.word(i + 1)); ok {
+			n := 2 + p.setMonthDay(month, day, p.word(i+2))
+			p.consume(i, n, "due")
+			return n
+		}
+	}
+
+	if day, ok := dayNumber(word); ok {
+		if month, ok := months[p.word(i+1)]; ok {
+			n := 2 + p.setMonthDay(month, day, p.word(i+2))
+			p.consume(i, n, "due")
+			return n
+		}
+	}
+
+	return 0
+}
+
+// setMonthDay picks the next occurrence of the day unless a year follows,
+// and returns how many extra words the year took.
+func (p *parser) setMonthDay(month time.Month, day int, year_word string) int {
+	if year, err := strconv.Atoi(year_word); err == nil && len(year_word) == 4 {
+		p.setDate(time.Date(year, month, day, 0, 0, 0, 0, p.now.Location()))
+		return 1
+	}
+
+	date := time.Date(p.now.Year(), month, day, 0, 0, 0, 0, p.now.Location())
+
+	if date.Before(startOfDay(p.now)) {
+		date = date.AddDate(1, 0, 0)
+	}
+
+	p.setDate(date)
+	return 0
+}
+
+func (p *parser) matchRelative(i int) int {
+	amount_word, unit := p.word(i+1), strings.TrimSuffix(p.word(i+2), "s")
+
+	amount := 0
+
+	switch {
+	case amount_word == "a" || amount_word == "an":
+		amount = 1
+	case durationPattern.MatchString(amount_word):
+		var err error
+
+		amount, err = strconv.Atoi(amount_word)
+		if err != nil || amount > maxRelativeAmount {
+			return 0
+		}
+	default:
+		return 0
+	}
+
+	var instant time.Time
+
+	switch unit {
+	case "minute", "min":
+		instant = p.now.Add(time.Duration(amount) * time.Minute)
+	case "hour", "hr":
+		instant = p.now.Add(time.Duration(amount) * time.Hour)
+	case "day":
+		p.setDate(p.now.AddDate(0, 0, amount))
+		p.consume(i, 3, "due")
+		return 3
+	case "week":
+		p.setDate(p.now.AddDate(0, 0, 7*amount))
+		p.consume(i, 3, "due")
+		return 3
+	case "month":
+		p.setDate(p.now.AddDate(0, amount, 0))
+		p.consume(i, 3, "due")
+		return 3
+	default:
+		return 0
+	}
+
+	p.instant = &instant
+	p.date_set = true
+	p.consume(i, 3, "due")
+
+	return 3
+}
+
+func (p *parser) matchTime(i int) int {
+	if p.clock != nil || p.instant != nil {
+		return 0
+	}
+
+	word := p.word(i)
+
+	switch word {
+	case "noon", "midday":
+		p.setClock(12, 0)
+		p.consume(i, 1, "time")
+		return 1
+	case "midnight":
+		p.setClock(23, 59)
+		p.consume(i, 1, "time")
+		return 1
+	}
+
+	match := clockPattern.FindStringSubmatch(word)
+	if match == nil {
+		return 0
+	}
+
+	n := 1
+	suffix := match[3]
+
+	// "9 am" written as two words
+	if suffix == "" && (p.word(i+1) == "am" || p.word(i+1) == "pm") {
+		suffix = p.word(i + 1)
+		n = 2
+	}
+
+	// A bare number is only a time after "at", otherwise it stays in the title
+	if suffix == "" && match[2] == "" && p.word(i-1) != "at" {
+		return 0
+	}
+
+	hour, _ := strconv.Atoi(match[1])
+	minute, _ := strconv.Atoi(match[2])
+
+	if suffix != "" && (hour < 1 || hour > 12) || hour > 23 || minute > 59 {
+		return 0
+	}
+
+	if suffix == "pm" && hour != 12 {
+		hour += 12
+	}
+	if suffix == "am" && hour == 12 {
+		hour = 0
+	}
+
+	p.setClock(hour, minute)
+	p.consume(i, n, "time")
+
+	return n
+}
+
+func (p *parser) due() time.Time {
+	if p.instant != nil {
+		return *p.instant
+	}
+
+	if p.date == nil && p.clock == nil {
+		p.result.DueDefaulted = true
+	}
+
+	if p.date == nil {
+		today := startOfDay(p.now)
+		p.date = &today
+
+		// A time that already passed today means tomorrow
+		if p.clock != nil && !atClock(today, *p.clock).After(p.now) {
+			tomorrow := today.AddDate(0, 0, 1)
+			p.date = &tomorrow
+		}
+	}
+
+	if p.clock == nil {
+		end_of_day := 23*time.Hour + 59*time.Minute + 59*time.Second
+		p.clock = &end_of_day
+	}
+
+	return atClock(*p.date, *p.clock)
+}
+
+// atClock sets the wall clock time of a day, which adding a duration to
+// midnight gets wrong on DST changes.
+func atClock(day time.Time, clock time.Duration) time.Time {
+	hour := int(clock / time.Hour)
+	minute := int(clock % time.Hour / time.Minute)
+	second := int(clock % time.Minute / time.Second)
+
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, second, 0, day.Location())
+}
+
+func (p *parser) nextWeekday(weekday time.Weekday, include_today bool) time.Time {
+	days := (int(weekday) - int(p.now.Weekday()) + 7) % 7
+
+	if days == 0 && !include_today {
+		days = 7
+	}
+
+	return p.now.AddDate(0, 0, days)
+}
+
+func dayNumber(word string) (int, bool) {
+	match := dayNumPattern.FindStringSubmatch(word)
+	if match == nil {
+		return 0, false
+	}
+
+	day, _ := strconv.Atoi(match[1])
+
+	return day, 1 <= day && day <= 31
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,37 @@
+package quickadd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2026, time.October, 21, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		text  string
+		title string
+		due   string
+	}{
+		{"Pay rent tomorrow 9am !high #finance", "Pay rent", "2026-10-22T09:00:00Z"},
+		{"Buy sun cream", "Buy sun cream", "2026-10-21T23:59:59Z"},
+		{"Grade sat essays", "Grade sat essays", "2026-10-21T23:59:59Z"},
+		{"Wed dress fitting", "Wed dress fitting", "2026-10-21T23:59:59Z"},
+		{"Call mom on sat", "Call mom", "2026-10-24T23:59:59Z"},
+		{"Standup next fri", "Standup", "2026-10-23T23:59:59Z"},
+		{"Standup this wed", "Standup", "2026-10-21T23:59:59Z"},
+		{"Report friday", "Report", "2026-10-23T23:59:59Z"},
+		{"Renew in 3 days", "Renew", "2026-10-24T23:59:59Z"},
+		{"Renew in 99999999999999999999 days", "Renew in 99999999999999999999 days", "2026-10-21T23:59:59Z"},
+		{"Renew in 5000 days", "Renew in 5000 days", "2026-10-21T23:59:59Z"},
+	}
+
+	for _, test := range tests {
+		result := Parse(test.text, now)
+
+		if result.Task.Title != test.title || result.Task.Due_date != test.due {
+			t.Errorf("Parse(%q) = %q due %s, want %q due %s", test.text, result.Task.Title, result.Task.Due_date, test.title, test.due)
+		}
+	}
+}
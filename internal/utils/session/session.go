@@ -1,12 +1,25 @@
 package session
 
 import (
+	"errors"
 	"net"
 	"net/http"
+	"time"
+	"todo/internal/http/context"
+	"todo/internal/utils/password"
 
 	"github.com/google/uuid"
 )
 
+// ReauthWindow is how recent a login confirms changes to an account in place
+// of the password, for users who only sign in with single sign-on.
+const ReauthWindow = 5 * time.Minute
+
+var (
+	ErrLoginRequired   = errors.New("no password set, log in again with single sign-on to confirm")
+	ErrInvalidPassword = errors.New("invalid password")
+)
+
 func MustGenerateUUID() string { return uuid.NewString() }
 
 func SetSessionCookie(w http.ResponseWriter, session_uuid string) {
@@ -45,6 +58,32 @@ func GetIP(r *http.Request) string {
 	return host
 }
 
+// LoggedInWithin reports whether the request comes with a session logged in
+// less than d ago. Access tokens never count as a recent login.
+func LoggedInWithin(r *http.Request, d time.Duration) bool {
+	login_at, ok := r.Context().Value(ctx.LoginAtKey).(int64)
+
+	return ok && time.Since(time.Unix(login_at, 0)) < d
+}
+
+// ConfirmIdentity checks the password confirming a change to an account, or
+// for users without one, that they logged in within ReauthWindow.
+func ConfirmIdentity(r *http.Request, hashed_password string, inp_password string) error {
+	if hashed_password == "" {
+		if !LoggedInWithin(r, ReauthWindow) {
+			return ErrLoginRequired
+		}
+
+		return nil
+	}
+
+	if !password.IsCorrectPassword([]byte(hashed_password), []byte(inp_password)) {
+		return ErrInvalidPassword
+	}
+
+	return nil
+}
+
 func Truncate(s string, max int) string {
 	if len(s) <= max { return s }
 	return s[:max]
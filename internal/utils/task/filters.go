@@ -0,0 +1,40 @@
+package task_utils
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// FilterParams are the GET /tasks params a saved filter can hold.
+var FilterParams = []string{"completed", "category", "due", "due_after", "search", "priority", "sort", "limit"}
+
+func EncodeFilter(params map[string]string) string {
+	values := url.Values{}
+
+	for key, value := range params {
+		values.Set(key, value)
+	}
+
+	return values.Encode()
+}
+
+func DecodeFilter(query string) (map[string]string, error) {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string]string{}
+
+	for key := range values {
+		params[key] = values.Get(key)
+	}
+
+	return params, nil
+}
+
+// FilterRequest builds a request carrying the params of a filter, so that it
+// goes through GetDynamicQuery like any GET /tasks request.
+func FilterRequest(params map[string]string) *http.Request {
+	return &http.Request{URL: &url.URL{Path: "/tasks", RawQuery: EncodeFilter(params)}}
+}
@@ -0,0 +1,91 @@
+package task_utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DueOffset is a due date relative to an anchor, written as signed amounts
+// of weeks, days, hours or minutes followed by an optional time of day, as in
+// "+2d 17:00", "-1w +1d" or "09:30".
+type DueOffset struct {
+	Days     int
+	Duration time.Duration
+	Clock    *time.Duration
+}
+
+var offsetUnits = map[byte]time.Duration{
+	'h': time.Hour,
+	'm': time.Minute,
+}
+
+func ParseDueOffset(offset string) (DueOffset, error) {
+	var due_offset DueOffset
+
+	for _, field := range strings.Fields(offset) {
+		if due_offset.Clock != nil {
+			return due_offset, errors.New("due offset time of day must come last")
+		}
+
+		if hour, minute, ok := strings.Cut(field, ":"); ok {
+			h, err_h := strconv.Atoi(hour)
+			m, err_m := strconv.Atoi(minute)
+
+			if err_h != nil || err_m != nil || h < 0 || h > 23 || m < 0 || m > 59 || len(minute) != 2 {
+				return due_offset, fmt.Errorf("invalid due offset time of day %q", field)
+			}
+
+			clock := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+			due_offset.Clock = &clock
+			continue
+		}
+
+		if len(field) < 3 || (field[0] != '+' && field[0] != '-') {
+			return due_offset, fmt.Errorf("invalid due offset %q, should be like +2d", field)
+		}
+
+		amount, err := strconv.Atoi(field[1 : len(field)-1])
+		if err != nil || amount < 0 {
+			return due_offset, fmt.Errorf("invalid due offset %q, should be like +2d", field)
+		}
+
+		if field[0] == '-' {
+			amount = -amount
+		}
+
+		switch unit := field[len(field)-1]; unit {
+		case 'w':
+			due_offset.Days += 7 * amount
+		case 'd':
+			due_offset.Days += amount
+		case 'h', 'm':
+			due_offset.Duration += time.Duration(amount) * offsetUnits[unit]
+		default:
+			return due_offset, fmt.Errorf("invalid due offset unit in %q, should be w, d, h or m", field)
+		}
+	}
+
+	return due_offset, nil
+}
+
+// Apply returns the due date for an anchor. Without a time of day, a due
+// date offset by whole days from a date-only anchor is due by the end of the
+// day.
+func (o DueOffset) Apply(anchor time.Time, date_only bool) time.Time {
+	due := anchor.AddDate(0, 0, o.Days).Add(o.Duration)
+
+	// Wall clock times, so that they hold across DST changes
+	if o.Clock != nil {
+		hour, minute := int(*o.Clock/time.Hour), int(*o.Clock%time.Hour/time.Minute)
+		return time.Date(due.Year(), due.Month(), due.Day(), hour, minute, 0, 0, due.Location())
+	}
+
+	if date_only && o.Duration == 0 {
+		return time.Date(due.Year(), due.Month(), due.Day(), 23, 59, 59, 0, due.Location())
+	}
+
+	return due
+}
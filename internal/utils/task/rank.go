@@ -0,0 +1,111 @@
+package task_utils
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Positions are lexicographic ranks over rankDigits, so a task moves between
+// two others by getting a rank between theirs, without touching other rows.
+// Ranks never end with the zero digit, which keeps a rank available below
+// any other.
+const rankDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// MaxRankLength is the length past which a user's ranks are rebalanced.
+const MaxRankLength = 48
+
+var ErrRankOrder = errors.New("rank bounds out of order")
+
+// DefaultRank is the rank the database gives new tasks, see init.sql. It
+// increases with time so that new tasks come last.
+func DefaultRank(t time.Time) string {
+	return "t" + strings.Replace(t.UTC().Format("20060102150405.000000"), ".", "", 1) + "i"
+}
+
+// RankBetween returns a rank strictly between a and b. An empty a is below
+// every rank and an empty b above every rank.
+func RankBetween(a string, b string) (string, error) {
+	if b != "" && a >= b {
+		return "", ErrRankOrder
+	}
+
+	return midpoint(a, b), nil
+}
+
+func midpoint(a string, b string) string {
+	if b != "" {
+		n := 0
+
+		for n < len(b) && digitAt(a, n) == b[n] {
+			n++
+		}
+
+		if n > 0 {
+			return b[:n] + midpoint(a[min(n, len(a)):], b[n:])
+		}
+	}
+
+	digit_a := 0
+	if a != "" {
+		digit_a = strings.IndexByte(rankDigits, a[0])
+	}
+
+	digit_b := len(rankDigits)
+	if b != "" {
+		digit_b = strings.IndexByte(rankDigits, b[0])
+	}
+
+	if digit_b-digit_a > 1 {
+		return string(rankDigits[(digit_a+digit_b+1)/2])
+	}
+
+	if len(b) > 1 {
+		return b[:1]
+	}
+
+	rest := ""
+	if a != "" {
+		rest = a[1:]
+	}
+
+	return string(rankDigits[digit_a]) + midpoint(rest, "")
+}
+
+func digitAt(rank string, i int) byte {
+	if i < len(rank) {
+		return rank[i]
+	}
+
+	return rankDigits[0]
+}
+
+// SpreadRanks returns n evenly spaced ascending ranks, all below the ranks
+// of new tasks.
+func SpreadRanks(n int) []string {
+	width := 1
+	for space := len(rankDigits); space <= n; space *= len(rankDigits) {
+		width++
+	}
+
+	space := 1
+	for range width {
+		space *= len(rankDigits)
+	}
+
+	ranks := make([]string, n)
+
+	for i := range ranks {
+		value := (i + 1) * space / (n + 1)
+		digits := make([]byte, width)
+
+		for j := width - 1; j >= 0; j-- {
+			digits[j] = rankDigits[value%len(rankDigits)]
+			value /= len(rankDigits)
+		}
+
+		ranks[i] = "m" + strings.TrimRight(string(digits), rankDigits[:1])
+	}
+
+	return ranks
+}
@@ -0,0 +1,101 @@
+package task_utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRankBetween(t *testing.T) {
+	tests := []struct {
+		a string
+		b string
+	}{
+		{"", ""},
+		{"", "m"},
+		{"m", ""},
+		{"a", "b"},
+		{"a", "a1"},
+		{"a1", "a2"},
+		{"az", "b"},
+		{"m", "m01"},
+		{"mzzz", "n"},
+		{"", "01"},
+		{"m5", DefaultRank(time.Now())},
+	}
+
+	for _, test := range tests {
+		rank, err := RankBetween(test.a, test.b)
+		if err != nil {
+			t.Errorf("RankBetween(%q, %q) error: %v", test.a, test.b, err)
+			continue
+		}
+
+		if rank <= test.a || (test.b != "" && rank >= test.b) {
+			t.Errorf("RankBetween(%q, %q) = %q, not between", test.a, test.b, rank)
+		}
+
+		if strings.HasSuffix(rank, rankDigits[:1]) {
+			t.Errorf("RankBetween(%q, %q) = %q, ends with the zero digit", test.a, test.b, rank)
+		}
+	}
+}
+
+func TestRankBetweenOrder(t *testing.T) {
+	for _, bounds := range [][2]string{{"b", "a"}, {"m", "m"}} {
+		if _, err := RankBetween(bounds[0], bounds[1]); err != ErrRankOrder {
+			t.Errorf("RankBetween(%q, %q) error = %v, want ErrRankOrder", bounds[0], bounds[1], err)
+		}
+	}
+}
+
+// Moving a task to the front over and over keeps finding room.
+func TestRankBetweenRepeated(t *testing.T) {
+	low, high := "", "m"
+
+	for range 200 {
+		rank, err := RankBetween(low, high)
+		if err != nil {
+			t.Fatalf("RankBetween(%q, %q) error: %v", low, high, err)
+		}
+
+		if rank >= high {
+			t.Fatalf("RankBetween(%q, %q) = %q, not below", low, high, rank)
+		}
+
+		high = rank
+	}
+}
+
+func TestSpreadRanks(t *testing.T) {
+	newest := DefaultRank(time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+	for _, n := range []int{0, 1, 2, 35, 36, 37, 1295, 1296, 5000} {
+		ranks := SpreadRanks(n)
+
+		if len(ranks) != n {
+			t.Fatalf("SpreadRanks(%d) returned %d ranks", n, len(ranks))
+		}
+
+		for i, rank := range ranks {
+			if i > 0 && rank <= ranks[i-1] {
+				t.Fatalf("SpreadRanks(%d)[%d] = %q, not above %q", n, i, rank, ranks[i-1])
+			}
+
+			if rank >= newest {
+				t.Fatalf("SpreadRanks(%d)[%d] = %q, not below new tasks", n, i, rank)
+			}
+
+			if len(rank) > MaxRankLength {
+				t.Fatalf("SpreadRanks(%d)[%d] = %q, longer than MaxRankLength", n, i, rank)
+			}
+		}
+
+		// Room is left between neighbours for later moves
+		for i := 1; i < len(ranks); i++ {
+			if _, err := RankBetween(ranks[i-1], ranks[i]); err != nil {
+				t.Fatalf("no rank between %q and %q: %v", ranks[i-1], ranks[i], err)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,6 +17,31 @@ func GetTaskUUID(path string) string {
 	return extracted
 }
 
+const dueLayout = "2006-01-02 15:04:05"
+
+// ParseDue accepts RFC 3339 with an offset, or the legacy layout read as wall
+// clock time in loc.
+func ParseDue(due string, loc *time.Location) (time.Time, error) {
+	date, err := time.Parse(time.RFC3339, due)
+	if err == nil {
+		return date, nil
+	}
+
+	return time.ParseInLocation(dueLayout, due, loc)
+}
+
+// NormalizeDue rewrites a due date as RFC 3339 in UTC, so that neither
+// validation nor postgres has to guess its timezone. A due date that can't be
+// parsed is returned unchanged for validation to reject.
+func NormalizeDue(due string, loc *time.Location) string {
+	date, err := ParseDue(strings.TrimSpace(due), loc)
+	if err != nil {
+		return due
+	}
+
+	return date.UTC().Format(time.RFC3339)
+}
+
 func ValidString(s string) bool {
 	for _, c := range s {
 		if !(('!' <= c && c <= '~') || (c == ' ')) {
@@ -27,33 +53,185 @@ func ValidString(s string) bool {
 	return true
 }
 
+// PriorityLevels is the numeric scale priorities sort by, from lowest to
+// highest. Sorting and validation follow it, but a new level also has to be
+// added to the CHECK constraint on tasks.priority in init.sql.
+var PriorityLevels = map[string]int{
+	"low":    1,
+	"medium": 2,
+	"high":   3,
+}
+
+// PriorityList lists the priority levels from lowest to highest, for error
+// messages, as in ('low', 'medium', 'high').
+func PriorityList() string {
+	levels := make([]string, 0, len(PriorityLevels))
+
+	for priority := range PriorityLevels {
+		levels = append(levels, priority)
+	}
+
+	sort.Slice(levels, func(i, j int) bool { return PriorityLevels[levels[i]] < PriorityLevels[levels[j]] })
+
+	return "('" + strings.Join(levels, "', '") + "')"
+}
+
+// allowedOrderBy maps the sort keys to the expression they order by.
 var allowedOrderBy = map[string]string{
-	"title":           "title",
-	"title desc":      "title desc",
-	"priority":        "priority",
-	"priority desc":   "priority desc",
-	"completed":       "completed",
-	"completed desc":  "completed desc",
-	"due_date":        "due_date",
-	"due_date desc ":  "due_date desc",
-	"category":        "category",
-	"category desc":   "category desc",
-	"created_at":      "created_at",
-	"created_at desc": "created_at desc",
-	"updated_at":      "updated_at",
-	"updated_at desc": "updated_at desc",
-}
-
-func GetDynamicQuery(user_id int, r *http.Request) (string, []any, error) {
-	condition_query := " WHERE user_id = $1 AND"
+	"title":      "title",
+	"priority":   priorityOrder(),
+	"completed":  "completed",
+	"due_date":   "due_date",
+	"category":   "category",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"position":   "position",
+	// Tasks completed before completed_at was recorded fall back on their
+	// last update
+	"completed_at": "COALESCE(completed_at, updated_at)",
+}
+
+func priorityOrder() string {
+	levels := make([]string, 0, len(PriorityLevels))
+
+	for priority := range PriorityLevels {
+		levels = append(levels, priority)
+	}
+
+	sort.Strings(levels)
+
+	order := "CASE priority"
+
+	for _, priority := range levels {
+		order += fmt.Sprintf(" WHEN '%s' THEN %d", priority, PriorityLevels[priority])
+	}
+
+	return order + " END"
+}
+
+// GetOrderBy parses a sort param of comma separated keys, each optionally
+// followed by :asc or :desc, as in "priority:desc,due_date". The older
+// "key desc" form is still accepted. The task id breaks ties so that pages
+// of equal keys stay stable.
+func GetOrderBy(param string) (string, error) {
+	var keys []string
+
+	seen := map[string]bool{}
+
+	for _, field := range strings.Split(param, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+
+		key, direction, found := strings.Cut(field, ":")
+		if !found {
+			key, direction, _ = strings.Cut(field, " ")
+		}
+
+		key, direction = strings.TrimSpace(key), strings.TrimSpace(direction)
+
+		order, ok := allowedOrderBy[key]
+		if !ok {
+			return "", fmt.Errorf("sort key %q not allowed", key)
+		}
+
+		if seen[key] {
+			return "", fmt.Errorf("sort key %q repeated", key)
+		}
+		seen[key] = true
+
+		switch direction {
+		case "", "asc":
+		case "desc":
+			order += " DESC"
+		default:
+			return "", fmt.Errorf("sort direction %q not in ('asc', 'desc')", direction)
+		}
+
+		keys = append(keys, order)
+	}
+
+	return " ORDER BY " + strings.Join(append(keys, "id"), ", "), nil
+}
+
+// Condition is a filter added to a dynamic query. Its SQL has a %d verb per
+// arg, replaced by the placeholder index of that arg.
+type Condition struct {
+	SQL  string
+	Args []any
+}
+
+// GetDynamicQuery builds the conditions, sort and limit of a GET /tasks
+// request. Due date params in the legacy layout are read in loc.
+func GetDynamicQuery(user_id int, loc *time.Location, r *http.Request) (string, []any, error) {
+	return GetFilteredQuery(user_id, loc, r, nil, "")
+}
+
+// GetFilteredQuery is GetDynamicQuery with extra conditions, and a sort used
+// when the request doesn't set one.
+func GetFilteredQuery(user_id int, loc *time.Location, r *http.Request, conditions []Condition, default_sort string) (string, []any, error) {
 	operation_query := ""
 	query := ""
+
+	query_params := GetQueryParams(r)
+
+	if query_params["sort"] == "" {
+		query_params["sort"] = default_sort
+	}
+
+	condition_query, args, arg_ind, err := getConditionQuery(user_id, loc, query_params, conditions)
+	if err != nil {
+		return "", args, err
+	}
+
+	if query_params["sort"] != "" {
+		param := query_params["sort"]
+		param = strings.TrimSpace(param)
+
+		sort_str, err := GetOrderBy(param)
+		if err != nil {
+			return "", args, err
+		}
+
+		operation_query += sort_str
+	}
+
+	if query_params["limit"] != "" {
+		param := query_params["limit"]
+		param = strings.TrimSpace(param)
+
+		limit, err := strconv.Atoi(param)
+
+		if err != nil {
+			return "", args, errors.New("limit param not a number")
+		}
+
+		if limit < 0 {
+			return "", args, errors.New("limit must be positive")
+		}
+
+		limit_str := fmt.Sprintf(" LIMIT $%d", arg_ind)
+		operation_query += limit_str
+		args = append(args, limit)
+		arg_ind++
+	}
+
+	query += condition_query + operation_query
+
+	return query, args, nil
+}
+
+func getConditionQuery(user_id int, loc *time.Location, query_params map[string]string, conditions []Condition) (string, []any, int, error) {
+	condition_query := " WHERE user_id = $1 AND"
 	args := []interface{}{}
 	arg_ind := 2
 
 	args = append(args, user_id)
 
-	query_params := GetQueryParams(r)
+	for _, condition := range conditions {
+		var condition_str string
+
+		condition_str, args, arg_ind = formatCondition(condition, args, arg_ind)
+		condition_query += " " + condition_str + " AND"
+	}
 
 	if query_params["completed"] != "" {
 		param := query_params["completed"]
@@ -61,7 +239,7 @@ func GetDynamicQuery(user_id int, r *http.Request) (string, []any, error) {
 		param = strings.TrimSpace(param)
 
 		if param != "false" && param != "true" {
-			return "", args, errors.New("completed param not a bool value")
+			return "", args, arg_ind, errors.New("completed param not a bool value")
 		}
 
 		completed_str := fmt.Sprintf(" completed = $%d", arg_ind)
@@ -70,7 +248,7 @@ func GetDynamicQuery(user_id int, r *http.Request) (string, []any, error) {
 		completed_bool, err := strconv.ParseBool(param)
 
 		if err != nil {
-			return "", args, err
+			return "", args, arg_ind, err
 		}
 		args = append(args, completed_bool)
 		arg_ind++
@@ -87,8 +265,10 @@ func GetDynamicQuery(user_id int, r *http.Request) (string, []any, error) {
 	}
 
 	if query_params["due"] != "" {
-		param := query_params["due"]
-		param = strings.TrimSpace(param)
+		param, err := dueParam("due", query_params["due"], loc)
+		if err != nil {
+			return "", args, arg_ind, err
+		}
 
 		due_str := fmt.Sprintf(" due_date <= $%d", arg_ind)
 		condition_query += due_str + " AND"
@@ -96,6 +276,18 @@ func GetDynamicQuery(user_id int, r *http.Request) (string, []any, error) {
 		arg_ind++
 	}
 
+	if query_params["due_after"] != "" {
+		param, err := dueParam("due_after", query_params["due_after"], loc)
+		if err != nil {
+			return "", args, arg_ind, err
+		}
+
+		due_after_str := fmt.Sprintf(" due_date >= $%d", arg_ind)
+		condition_query += due_after_str + " AND"
+		args = append(args, param)
+		arg_ind++
+	}
+
 	if query_params["search"] != "" {
 		param := query_params["search"]
 		param = strings.TrimSpace(param)
@@ -111,8 +303,8 @@ func GetDynamicQuery(user_id int, r *http.Request) (string, []any, error) {
 		param = strings.ToLower(param)
 		param = strings.TrimSpace(param)
 
-		if param != "low" && param != "medium" && param != "high" {
-			return "", args, errors.New("priority param not in ('low', 'medium', 'high')")
+		if _, ok := PriorityLevels[param]; !ok {
+			return "", args, arg_ind, errors.New("priority param not in " + PriorityList())
 		}
 
 		priority_str := fmt.Sprintf(" priority = $%d", arg_ind)
@@ -123,46 +315,29 @@ func GetDynamicQuery(user_id int, r *http.Request) (string, []any, error) {
 
 	condition_query, _ = strings.CutSuffix(condition_query, " AND")
 
-	if query_params["sort"] != "" {
-		param := query_params["sort"]
-		param = strings.ToLower(param)
-		param = strings.TrimSpace(param)
-
-		if _, ok := allowedOrderBy[param]; !ok {
-			return "", args, errors.New("sort param not allowed")
-		}
+	return condition_query, args, arg_ind, nil
+}
 
-		sort_str := fmt.Sprintf(" ORDER BY %s", param)
-		operation_query += sort_str
+// dueParam reads a due date param like a due date, so that postgres doesn't
+// read it in its own timezone.
+func dueParam(key string, param string, loc *time.Location) (string, error) {
+	date, err := ParseDue(strings.TrimSpace(param), loc)
+	if err != nil {
+		return "", fmt.Errorf("%s param should be RFC 3339 or YYYY-MM-DD HH:MM:SS", key)
 	}
 
-	if query_params["limit"] != "" {
-		param := query_params["limit"]
-		param = strings.TrimSpace(param)
-
-		limit, err := strconv.Atoi(param)
-
-		if err != nil {
-			return "", args, errors.New("limit param not a number")
-		}
+	return date.UTC().Format(time.RFC3339), nil
+}
 
-		if limit < 0 {
-			return "", args, errors.New("limit must be positive")
-		}
+func formatCondition(condition Condition, args []any, arg_ind int) (string, []any, int) {
+	indexes := make([]any, len(condition.Args))
 
-		limit_str := fmt.Sprintf(" LIMIT $%d", arg_ind)
-		operation_query += limit_str
-		args = append(args, limit)
+	for i := range condition.Args {
+		indexes[i] = arg_ind
 		arg_ind++
 	}
 
-	if condition_query == " WHERE" {
-		condition_query = ""
-	}
-
-	query += condition_query + operation_query
-
-	return query, args, nil
+	return fmt.Sprintf(condition.SQL, indexes...), append(args, condition.Args...), arg_ind
 }
 
 func GetQueryParams(r *http.Request) map[string]string {
@@ -170,6 +345,7 @@ func GetQueryParams(r *http.Request) map[string]string {
 		"completed": r.URL.Query().Get("completed"),
 		"category":  r.URL.Query().Get("category"),
 		"due":       r.URL.Query().Get("due"),
+		"due_after": r.URL.Query().Get("due_after"),
 		"search":    r.URL.Query().Get("search"),
 		"sort":      r.URL.Query().Get("sort"),
 		"limit":     r.URL.Query().Get("limit"),
@@ -184,6 +360,23 @@ func TrimSpace(task *models.NewTask) {
 	task.Category = strings.TrimSpace(task.Category)
 }
 
+// RenderTask rewrites the timestamps of a task, as returned by the database,
+// in the given timezone.
+func RenderTask(task *models.Task, loc *time.Location) {
+	task.Due_date = renderTime(task.Due_date, loc)
+	task.Created_at = renderTime(task.Created_at, loc)
+	task.Updated_at = renderTime(task.Updated_at, loc)
+}
+
+func renderTime(timestamp string, loc *time.Location) string {
+	date, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return timestamp
+	}
+
+	return date.In(loc).Format(time.RFC3339)
+}
+
 func GetUpdateQuery(user_id int, task_uuid string, update_task models.UpdateTask) (string, []any) {
 	update_query := "UPDATE tasks SET "
 	args := []any{}
@@ -219,11 +412,26 @@ func GetUpdateQuery(user_id int, task_uuid string, update_task models.UpdateTask
 
 	if update_task.Completed != nil {
 		update_query += fmt.Sprintf("completed = $%d, ", arg_ind)
+		// Completing an already completed task keeps its completion time
+		update_query += fmt.Sprintf("completed_at = CASE WHEN $%d THEN COALESCE(completed_at, CURRENT_TIMESTAMP) END, ", arg_ind)
+
+		// A task completed or reopened without a status falls back to the
+		// first done or open status
+		if update_task.Status_ID == nil {
+			update_query += fmt.Sprintf("status_id = CASE WHEN completed = $%d THEN status_id END, ", arg_ind)
+		}
 
 		args = append(args, *update_task.Completed)
 		arg_ind++
 	}
 
+	if update_task.Status_ID != nil {
+		update_query += fmt.Sprintf("status_id = $%d, ", arg_ind)
+
+		args = append(args, *update_task.Status_ID)
+		arg_ind++
+	}
+
 	if update_query == "UPDATE tasks SET " {
 		update_query = ""
 	} else {
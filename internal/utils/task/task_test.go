@@ -0,0 +1,47 @@
+package task_utils
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestGetDynamicQueryDue(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+
+	tests := []struct {
+		query string
+		want  string
+		err   bool
+	}{
+		{"due=2026-10-22 09:00:00", "2026-10-22T07:00:00Z", false},
+		{"due_after=2026-10-22T09:00:00%2B02:00", "2026-10-22T07:00:00Z", false},
+		{"due=2026-01-10 09:00:00", "2026-01-10T08:00:00Z", false},
+		{"due=tomorrow", "", true},
+	}
+
+	for _, test := range tests {
+		r := &http.Request{URL: &url.URL{Path: "/tasks", RawQuery: test.query}}
+
+		_, args, err := GetDynamicQuery(1, loc, r)
+		if test.err {
+			if err == nil {
+				t.Errorf("GetDynamicQuery(%q) accepted the due date", test.query)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("GetDynamicQuery(%q) error: %v", test.query, err)
+			continue
+		}
+
+		if len(args) != 2 || args[1] != test.want {
+			t.Errorf("GetDynamicQuery(%q) args = %v, want due %s", test.query, args, test.want)
+		}
+	}
+}
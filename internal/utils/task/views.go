@@ -0,0 +1,106 @@
+package task_utils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// View is a built-in task list. Its conditions are computed from the current
+// time in the user's timezone, so "today" follows the user's calendar day.
+type View struct {
+	Name       string
+	Conditions []Condition
+	Sort       string
+}
+
+var ViewNames = []string{"today", "overdue", "upcoming", "completed-recently"}
+
+const DefaultViewDays = 7
+
+const maxViewDays = 365
+
+var ErrUnknownView = errors.New("unknown view")
+
+// GetView returns the named view. Today holds the open tasks due on the
+// current day, overdue the open tasks already past due, upcoming the open
+// tasks due in the days after today and completed-recently the tasks
+// completed in the last days.
+func GetView(name string, now time.Time, days int) (View, error) {
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	tomorrow := start.AddDate(0, 0, 1)
+
+	switch name {
+	case "today":
+		return View{Name: name, Sort: "due_date", Conditions: []Condition{
+			{SQL: "completed = $%d", Args: []any{false}},
+			{SQL: "due_date >= $%d AND due_date < $%d", Args: []any{start, tomorrow}},
+		}}, nil
+	case "overdue":
+		return View{Name: name, Sort: "due_date", Conditions: []Condition{
+			{SQL: "completed = $%d", Args: []any{false}},
+			{SQL: "due_date < $%d", Args: []any{now}},
+		}}, nil
+	case "upcoming":
+		return View{Name: name, Sort: "due_date", Conditions: []Condition{
+			{SQL: "completed = $%d", Args: []any{false}},
+			{SQL: "due_date >= $%d AND due_date < $%d", Args: []any{tomorrow, tomorrow.AddDate(0, 0, days)}},
+		}}, nil
+	case "completed-recently":
+		return View{Name: name, Sort: "completed_at desc", Conditions: []Condition{
+			{SQL: "completed = $%d", Args: []any{true}},
+			{SQL: "COALESCE(completed_at, updated_at) >= $%d", Args: []any{now.AddDate(0, 0, -days)}},
+		}}, nil
+	}
+
+	return View{}, ErrUnknownView
+}
+
+// GetViewDays reads the days param of the upcoming and completed-recently
+// views.
+func GetViewDays(r *http.Request) (int, error) {
+	param := strings.TrimSpace(r.URL.Query().Get("days"))
+	if param == "" {
+		return DefaultViewDays, nil
+	}
+
+	days, err := strconv.Atoi(param)
+	if err != nil {
+		return 0, errors.New("days param not a number")
+	}
+
+	if days < 1 || days > maxViewDays {
+		return 0, fmt.Errorf("days must be between 1 and %d", maxViewDays)
+	}
+
+	return days, nil
+}
+
+// GetViewCountQuery counts the tasks of every view in one query. The filters
+// of the request apply to all of them, while sort and limit are ignored.
+func GetViewCountQuery(user_id int, loc *time.Location, r *http.Request, views []View) (string, []any, error) {
+	condition_query, args, arg_ind, err := getConditionQuery(user_id, loc, GetQueryParams(r), nil)
+	if err != nil {
+		return "", args, err
+	}
+
+	var columns []string
+
+	for _, view := range views {
+		var view_conditions []string
+
+		for _, condition := range view.Conditions {
+			var condition_str string
+
+			condition_str, args, arg_ind = formatCondition(condition, args, arg_ind)
+			view_conditions = append(view_conditions, condition_str)
+		}
+
+		columns = append(columns, "COUNT(*) FILTER (WHERE "+strings.Join(view_conditions, " AND ")+")")
+	}
+
+	return "SELECT " + strings.Join(columns, ", ") + " FROM tasks" + condition_query, args, nil
+}
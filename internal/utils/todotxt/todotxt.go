@@ -0,0 +1,245 @@
+// Package todotxt_utils converts tasks to and from the todo.txt format.
+//
+// Exporting and importing a task again keeps its title, priority, category,
+// due date, completion and every key:value, +project and @context token that
+// isn't mapped onto a column. Title words that would read as one of those
+// tokens, or as the leading completion, priority or date markers, are
+// escaped with a backslash, which is taken off again on import. Two things
+// don't survive a round trip: runs of whitespace in the title collapse to
+// one space, and whitespace in a category is written as "_" since a +project
+// token can't contain spaces. Creation and completion dates are written for
+// other tools but ignored on import.
+package todotxt_utils
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+	"todo/internal/models"
+	"unicode"
+)
+
+const dateLayout = "2006-01-02"
+
+// todo.txt due dates have no time, so they are due by the end of the day and
+// any other time is kept in a separate due_time key.
+const endOfDay = "23:59:59"
+
+// Tasks without a +project or @context still need a category.
+const DefaultCategory = "inbox"
+
+var priorityLetters = map[string]string{
+	"high":   "A",
+	"medium": "B",
+	"low":    "C",
+}
+
+var priorityPattern = regexp.MustCompile(`^\([A-Z]\)$`)
+
+var datePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// FormatTask writes a task as a todo.txt line with its dates in loc.
+func FormatTask(task models.Task, extras string, completed_at string, loc *time.Location) string {
+	var tokens []string
+
+	letter := priorityLetters[task.Priority]
+
+	if task.Completed {
+		tokens = append(tokens, "x")
+
+		if completed := formatDate(completed_at, loc); completed != "" {
+			tokens = append(tokens, completed)
+		}
+	} else if letter != "" {
+		tokens = append(tokens, "("+letter+")")
+	}
+
+	if created := formatDate(task.Created_at, loc); created != "" {
+		tokens = append(tokens, created)
+	}
+
+	for i, word := range strings.Fields(task.Title) {
+		tokens = append(tokens, escapeWord(word, i == 0))
+	}
+
+	if task.Category != "" {
+		tokens = append(tokens, "+"+strings.Join(strings.Fields(task.Category), "_"))
+	}
+
+	if due, err := time.Parse(time.RFC3339Nano, task.Due_date); err == nil {
+		due = due.In(loc)
+		tokens = append(tokens, "due:"+due.Format(dateLayout))
+
+		if due.Format(time.TimeOnly) != endOfDay {
+			tokens = append(tokens, "due_time:"+due.Format(time.TimeOnly))
+		}
+	}
+
+	// Completed tasks drop the priority from the front, by convention
+	if task.Completed && letter != "" {
+		tokens = append(tokens, "pri:"+letter)
+	}
+
+	if extras != "" {
+		tokens = append(tokens, extras)
+	}
+
+	return strings.Join(tokens, " ")
+}
+
+// escapeWord escapes a title word ParseLine would take for something else.
+// The first word could also be read as one of the markers in front of it.
+func escapeWord(word string, first bool) string {
+	special := strings.HasPrefix(word, `\`) ||
+		(len(word) > 1 && (word[0] == '+' || word[0] == '@')) ||
+		isKeyValue(word) ||
+		(first && (word == "x" || priorityPattern.MatchString(word) || datePattern.MatchString(word)))
+
+	if special {
+		return `\` + word
+	}
+
+	return word
+}
+
+func ReadTasks(r io.Reader) ([]models.ImportTask, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	var rows []models.ImportTask
+
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		rows = append(rows, ParseLine(line, text))
+	}
+
+	return rows, scanner.Err()
+}
+
+func ParseLine(line int, text string) models.ImportTask {
+	row := models.ImportTask{Line: line}
+	tokens := strings.Fields(text)
+
+	priority := ""
+
+	if len(tokens) > 0 && tokens[0] == "x" {
+		row.Completed = true
+		tokens = tokens[1:]
+
+		// Completion date, then creation date
+		for i := 0; i < 2 && len(tokens) > 0 && datePattern.MatchString(tokens[0]); i++ {
+			tokens = tokens[1:]
+		}
+	} else {
+		if len(tokens) > 0 && priorityPattern.MatchString(tokens[0]) {
+			priority = tokens[0][1:2]
+			tokens = tokens[1:]
+		}
+
+		if len(tokens) > 0 && datePattern.MatchString(tokens[0]) {
+			tokens = tokens[1:]
+		}
+	}
+
+	var title, extras []string
+	var due, due_time, category string
+
+	for _, token := range tokens {
+		switch {
+		case len(token) > 1 && token[0] == '\\':
+			title = append(title, token[1:])
+		case len(token) > 1 && (token[0] == '+' || token[0] == '@'):
+			if category == "" {
+				category = token[1:]
+				continue
+			}
+			extras = append(extras, token)
+		case isKeyValue(token):
+			key, val, _ := strings.Cut(token, ":")
+
+			switch key {
+			case "due":
+				due = val
+			case "due_time":
+				due_time = val
+			case "pri":
+				priority = val
+			default:
+				extras = append(extras, token)
+			}
+		default:
+			title = append(title, token)
+		}
+	}
+
+	if category == "" {
+		category = DefaultCategory
+	}
+
+	row.Task = models.NewTask{
+		Title:    strings.Join(title, " "),
+		Due_date: dueDate(due, due_time),
+		Priority: priorityName(priority),
+		Category: category,
+	}
+	row.Extras = strings.Join(extras, " ")
+
+	return row
+}
+
+// isKeyValue excludes URLs such as https://example.com and times such as
+// 10:30, which todo.txt treats as plain text.
+func isKeyValue(token string) bool {
+	key, val, ok := strings.Cut(token, ":")
+
+	return ok && key != "" && val != "" && !strings.HasPrefix(val, "//") && unicode.IsLetter(rune(key[0]))
+}
+
+func priorityName(letter string) string {
+	switch letter {
+	case "A":
+		return "high"
+	case "B", "":
+		return "medium"
+	default:
+		return "low"
+	}
+}
+
+// dueDate returns the due date in the legacy layout, read in the importing
+// user's timezone. An unparsable date is passed through so validation
+// reports it.
+func dueDate(due string, due_time string) string {
+	if due == "" {
+		return ""
+	}
+
+	if due_time == "" {
+		due_time = endOfDay
+	}
+
+	if len(due_time) == len("15:04") {
+		due_time += ":00"
+	}
+
+	return due + " " + due_time
+}
+
+func formatDate(timestamp string, loc *time.Location) string {
+	date, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return ""
+	}
+
+	return date.In(loc).Format(dateLayout)
+}
@@ -0,0 +1,65 @@
+package todotxt_utils
+
+import (
+	"testing"
+	"time"
+	"todo/internal/models"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		text      string
+		title     string
+		due       string
+		priority  string
+		category  string
+		extras    string
+		completed bool
+	}{
+		{"(A) 2026-10-20 Call mom +family due:2026-10-22", "Call mom", "2026-10-22 23:59:59", "high", "family", "", false},
+		{"x 2026-10-21 2026-10-20 Pay rent +home pri:C", "Pay rent", "", "low", "home", "", true},
+		{"Read https://example.com at 10:30 @work +extra key:val", "Read https://example.com at 10:30", "", "medium", "work", "+extra key:val", false},
+		{"Standup due:2026-10-22 due_time:09:30", "Standup", "2026-10-22 09:30:00", "medium", DefaultCategory, "", false},
+		{`\x \(A) \+tag \due:soon`, "x (A) +tag due:soon", "", "medium", DefaultCategory, "", false},
+	}
+
+	for _, test := range tests {
+		row := ParseLine(1, test.text)
+
+		if row.Task.Title != test.title || row.Task.Due_date != test.due || row.Task.Priority != test.priority ||
+			row.Task.Category != test.category || row.Extras != test.extras || row.Completed != test.completed {
+			t.Errorf("ParseLine(%q) = %+v extras %q completed %t", test.text, row.Task, row.Extras, row.Completed)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+
+	tests := []struct {
+		task   models.Task
+		extras string
+		due    string
+	}{
+		{models.Task{Title: "Plain title", Priority: "high", Category: "work"}, "", ""},
+		{models.Task{Title: "x marks the spot", Priority: "medium", Category: "home"}, "", ""},
+		{models.Task{Title: "(B) 2026-01-01 looks like markers", Priority: "low", Category: "home"}, "", ""},
+		{models.Task{Title: `+plus @at key:val \slash`, Priority: "medium", Category: "misc"}, "", ""},
+		{models.Task{Title: "Done one", Completed: true, Priority: "low", Category: "misc"}, "@phone rec:1w", ""},
+		{models.Task{Title: "Due late", Priority: "medium", Category: "misc", Due_date: "2026-10-22T21:59:59Z"}, "", "2026-10-22 23:59:59"},
+		{models.Task{Title: "Due at nine", Priority: "medium", Category: "misc", Due_date: "2026-10-22T07:00:00Z"}, "", "2026-10-22 09:00:00"},
+	}
+
+	for _, test := range tests {
+		line := FormatTask(test.task, test.extras, "2026-10-21T08:00:00Z", loc)
+		row := ParseLine(1, line)
+
+		if row.Task.Title != test.task.Title || row.Task.Priority != test.task.Priority || row.Task.Category != test.task.Category ||
+			row.Completed != test.task.Completed || row.Extras != test.extras || row.Task.Due_date != test.due {
+			t.Errorf("ParseLine(FormatTask(%+v)) = %+v extras %q completed %t, from line %q", test.task, row.Task, row.Extras, row.Completed, line)
+		}
+	}
+}
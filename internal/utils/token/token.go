@@ -0,0 +1,34 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// Generate returns a url-safe random secret of n bytes of entropy.
+func Generate(n int) (string, error) {
+	buf := make([]byte, n)
+
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Hash is used for high-entropy secrets only, which do not need a slow hash
+// and have to be looked up by their hashed value.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// AccessTokenPrefix marks personal access tokens, so that leaked ones are
+// easy to scan for.
+const AccessTokenPrefix = "todo_pat_"
+
+// Scopes a personal access token can be given. Routes outside of them are
+// only open to sessions.
+var Scopes = []string{"tasks:read", "tasks:write", "account:read", "account:write"}
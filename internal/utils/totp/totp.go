@@ -0,0 +1,141 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 defaults, which authenticator apps assume when the URI leaves
+// them out
+const (
+	Period = 30
+	Digits = 6
+	// Skew is how many periods a code may be early or late, for clock drift
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random 160 bit secret, base32 encoded as apps
+// expect it.
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 20)
+
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(buf), nil
+}
+
+// URI is the otpauth URI apps read, usually from a QR code.
+func URI(issuer string, account string, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(Period))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Code is the code of the period t falls in.
+func Code(secret string, t time.Time) (string, error) {
+	return code(secret, t.Unix()/Period)
+}
+
+// Validate checks a code against the periods around t. It returns the period
+// of the code, so that the caller can refuse it being used again.
+func Validate(secret string, input string, t time.Time) (int64, bool) {
+	input = strings.ReplaceAll(input, " ", "")
+
+	if len(input) != Digits {
+		return 0, false
+	}
+
+	counter := t.Unix() / Period
+
+	for step := counter - Skew; step <= counter+Skew; step++ {
+		expected, err := code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(input)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func code(secret string, counter int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for range Digits {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%modulo), nil
+}
+
+// RecoveryCodes returns n one-time codes of 50 random bits, written as
+// "xxxxx-xxxxx". They are stored and looked up in the form
+// NormalizeRecoveryCode gives, which they already are in.
+func RecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+
+	for i := range codes {
+		// 56 bits, of which the first 10 characters hold 50
+		buf := make([]byte, 7)
+
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+
+		codes[i] = NormalizeRecoveryCode(encoding.EncodeToString(buf)[:10])
+	}
+
+	return codes, nil
+}
+
+// NormalizeRecoveryCode lets a recovery code be typed in any case, with or
+// without its dash. The dash goes after the fifth character whatever the
+// length, which also keeps the shorter codes given out before readable.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, " ", "")
+	code = strings.ReplaceAll(code, "-", "")
+
+	if len(code) <= 5 {
+		return code
+	}
+
+	return code[:5] + "-" + code[5:]
+}
@@ -0,0 +1,126 @@
+package totp
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"todo/internal/utils/token"
+)
+
+// "12345678901234567890", the SHA1 seed of RFC 6238 appendix B
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		got, err := Code(rfcSecret, time.Unix(test.unix, 0))
+		if err != nil {
+			t.Errorf("Code(%d) error: %v", test.unix, err)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("Code(%d) = %s, want %s", test.unix, got, test.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	tests := []struct {
+		input string
+		at    time.Time
+		ok    bool
+	}{
+		{"081804", now, true},
+		{"081 804", now, true},
+		{"081804", now.Add(Period * time.Second), true},
+		{"081804", now.Add(-Period * time.Second), true},
+		{"081804", now.Add(2 * Period * time.Second), false},
+		{"081805", now, false},
+		{"81804", now, false},
+	}
+
+	for _, test := range tests {
+		step, ok := Validate(rfcSecret, test.input, test.at)
+		if ok != test.ok {
+			t.Errorf("Validate(%q, %d) = %v, want %v", test.input, test.at.Unix(), ok, test.ok)
+			continue
+		}
+
+		if ok && step != now.Unix()/Period {
+			t.Errorf("Validate(%q, %d) step = %d, want %d", test.input, test.at.Unix(), step, now.Unix()/Period)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := RecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+
+	stored := map[string]bool{}
+
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("recovery code %q not in xxxxx-xxxxx form", code)
+		}
+
+		// As ConfirmTOTP stores it
+		stored[token.Hash(NormalizeRecoveryCode(code))] = true
+	}
+
+	if len(stored) != len(codes) {
+		t.Errorf("%d distinct recovery codes, want %d", len(stored), len(codes))
+	}
+
+	code := codes[0]
+	plain := strings.ReplaceAll(code, "-", "")
+
+	// As LoginTOTP looks them up
+	for _, input := range []string{code, strings.ToUpper(code), plain, " " + strings.ToUpper(plain) + " ", code[:3] + " " + code[3:]} {
+		if !stored[token.Hash(NormalizeRecoveryCode(input))] {
+			t.Errorf("recovery code %q typed as %q not redeemable", code, input)
+		}
+	}
+
+	if stored[token.Hash(NormalizeRecoveryCode(code[:9]))] {
+		t.Errorf("truncated recovery code %q redeemable", code[:9])
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"ABCDE-FGHIJ", "abcde-fghij"},
+		{"abcdefghij", "abcde-fghij"},
+		{" abc de fghij ", "abcde-fghij"},
+		// Codes given out before they were 10 characters long
+		{"ABCDEFGH", "abcde-fgh"},
+		{"abc", "abc"},
+	}
+
+	for _, test := range tests {
+		if got := NormalizeRecoveryCode(test.input); got != test.want {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
@@ -5,17 +5,38 @@ import (
 	"encoding/json"
 	"context"
 	"errors"
+	"fmt"
+	"slices"
 	"net/http"
 	"strings"
 	"time"
 	"todo/internal/models"
 	"todo/internal/storage/postgres"
 	"todo/internal/utils/task"
+	"todo/internal/utils/token"
 )
 
-const layout = "2006-01-02 15:04:05"
+func ValidateTimezone(timezone string) (*time.Location, error) {
+	if timezone == "" || timezone == "Local" {
+		return nil, errors.New("timezone must be an IANA name such as Europe/Berlin")
+	}
+
+	return time.LoadLocation(timezone)
+}
+
+// ValidateTask checks a new task. A due date in the legacy layout is read in
+// loc, the user's timezone.
+func ValidateTask(DB *sql.DB, ctx context.Context, user_id int, loc *time.Location, task models.NewTask) error {
+	return validateTask(DB, ctx, user_id, loc, task, true)
+}
+
+// ValidateImportedTask lets completed tasks keep a due date in the past, as
+// exports of finished work have.
+func ValidateImportedTask(DB *sql.DB, ctx context.Context, user_id int, loc *time.Location, row models.ImportTask) error {
+	return validateTask(DB, ctx, user_id, loc, row.Task, !row.Completed)
+}
 
-func ValidateTask(DB *sql.DB, ctx context.Context, user_id int, task models.NewTask) error {
+func validateTask(DB *sql.DB, ctx context.Context, user_id int, loc *time.Location, task models.NewTask, future_due bool) error {
 	if task.Title == "" || task.Category == "" {
 		return errors.New("insertion requirements not met, can't be empty")
 	}
@@ -28,23 +49,159 @@ func ValidateTask(DB *sql.DB, ctx context.Context, user_id int, task models.NewT
 		return errors.New("unique task violation: task already exists")
 	}
 
-	if task.Priority != "low" && task.Priority != "medium" && task.Priority != "high" {
-			return errors.New("insertion requirements not met, priority must be in ('low', 'medium', 'high')")
-		} 
+	if _, ok := task_utils.PriorityLevels[task.Priority]; !ok {
+		return errors.New("insertion requirements not met, priority must be in " + task_utils.PriorityList())
+	}
 
-	date, err := time.Parse(layout, task.Due_date)
+	date, err := task_utils.ParseDue(task.Due_date, loc)
 
 	if err != nil {
-		return errors.New("due time requirements not met, should be YYYY-MM-DD  HH:MM:SS")
+		return errors.New("due time requirements not met, should be RFC 3339 or YYYY-MM-DD HH:MM:SS")
 	}
 
-	if time.Since(date) >= 0 {
+	if future_due && time.Since(date) >= 0 {
 		return errors.New("due time requirements not met, should be > Current time")
 	}
 
 	return nil
 }
 
+func ValidateStatusName(name string) error {
+	if name == "" {
+		return errors.New("status requirements not met, name can't be empty")
+	}
+
+	if len(name) > 50 || !task_utils.ValidString(name) {
+		return errors.New("status requirements not met, not valid string")
+	}
+
+	return nil
+}
+
+func ValidateWIPLimit(wip_limit *int) error {
+	if wip_limit != nil && *wip_limit < 0 {
+		return errors.New("status requirements not met, wip_limit can't be negative")
+	}
+
+	return nil
+}
+
+// ValidateStatuses checks that a user's statuses keep at least one open and
+// one done status, which completed maps to.
+func ValidateStatuses(statuses []models.Status) error {
+	open, done := false, false
+
+	for _, status := range statuses {
+		if status.Done {
+			done = true
+		} else {
+			open = true
+		}
+	}
+
+	if !open || !done {
+		return errors.New("status requirements not met, at least one open and one done status are needed")
+	}
+
+	return nil
+}
+
+const maxTemplateTasks = 100
+
+// ValidateTemplate checks a template as saved. Due dates are only checked
+// once the template is instantiated against an anchor.
+func ValidateTemplate(template models.Template) error {
+	if template.Name == "" {
+		return errors.New("template requirements not met, name can't be empty")
+	}
+
+	if len(template.Name) > 100 || !task_utils.ValidString(template.Name) {
+		return errors.New("template requirements not met, not valid string")
+	}
+
+	if len(template.Tasks) == 0 || len(template.Tasks) > maxTemplateTasks {
+		return fmt.Errorf("template requirements not met, needs 1 to %d tasks", maxTemplateTasks)
+	}
+
+	titles := map[string]bool{}
+
+	for ind, task := range template.Tasks {
+		if task.Title == "" || task.Category == "" {
+			return fmt.Errorf("template task %d: can't be empty", ind+1)
+		}
+
+		if !task_utils.ValidString(task.Title) || !task_utils.ValidString(task.Category) {
+			return fmt.Errorf("template task %d: not valid string", ind+1)
+		}
+
+		if _, ok := task_utils.PriorityLevels[task.Priority]; !ok {
+			return fmt.Errorf("template task %d: priority must be in %s", ind+1, task_utils.PriorityList())
+		}
+
+		if _, err := task_utils.ParseDueOffset(task.Due_offset); err != nil {
+			return fmt.Errorf("template task %d: %w", ind+1, err)
+		}
+
+		if titles[task.Title] {
+			return fmt.Errorf("template task %d: duplicate title %q", ind+1, task.Title)
+		}
+		titles[task.Title] = true
+	}
+
+	return nil
+}
+
+const maxTokenDays = 365
+
+func ValidateAccessToken(new_token models.NewAccessToken) error {
+	if new_token.Name == "" || len(new_token.Name) > 100 || !task_utils.ValidString(new_token.Name) {
+		return errors.New("token requirements not met, name can't be empty or not valid string")
+	}
+
+	if len(new_token.Scopes) == 0 {
+		return errors.New("token requirements not met, at least one scope is needed")
+	}
+
+	for _, scope := range new_token.Scopes {
+		if !slices.Contains(token.Scopes, scope) {
+			return fmt.Errorf("unknown scope %q, should be in %v", scope, token.Scopes)
+		}
+	}
+
+	if days := new_token.Expires_in_days; days != nil && (*days < 1 || *days > maxTokenDays) {
+		return fmt.Errorf("token requirements not met, expires_in_days should be 1 to %d", maxTokenDays)
+	}
+
+	return nil
+}
+
+func ValidateFilterName(name string) error {
+	if name == "" {
+		return errors.New("filter requirements not met, name can't be empty")
+	}
+
+	if len(name) > 100 || !task_utils.ValidString(name) {
+		return errors.New("filter requirements not met, not valid string")
+	}
+
+	return nil
+}
+
+// ValidateFilter checks the params of a saved filter the way GET /tasks
+// would. It runs again whenever a filter is read, so that a filter broken by
+// a change of the accepted params is reported rather than failing later.
+func ValidateFilter(params map[string]string) error {
+	for key := range params {
+		if !slices.Contains(task_utils.FilterParams, key) {
+			return fmt.Errorf("unknown filter param %q", key)
+		}
+	}
+
+	_, _, err := task_utils.GetDynamicQuery(0, time.UTC, task_utils.FilterRequest(params))
+
+	return err
+}
+
 var allowedEmailChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789.-"
 
 func ValidateEmail(email string) error {
@@ -150,9 +307,7 @@ func ValidatePassword(password string) error {
 	return nil
 }
 
-func GetValidateUpdateParams(DB *sql.DB, ctx context.Context, user_id int, r *http.Request) (models.UpdateTask, error) {
-	// Title
-
+func GetValidateUpdateParams(DB *sql.DB, ctx context.Context, user_id int, loc *time.Location, r *http.Request) (models.UpdateTask, error) {
 	var update_task models.UpdateTask
 
 	err := json.NewDecoder(r.Body).Decode(&update_task)
@@ -161,31 +316,44 @@ func GetValidateUpdateParams(DB *sql.DB, ctx context.Context, user_id int, r *ht
 		return update_task, err
 	}
 
+	if update_task.Due_date != nil {
+		due := task_utils.NormalizeDue(*update_task.Due_date, loc)
+		update_task.Due_date = &due
+	}
+
+	return update_task, ValidateUpdateTask(DB, ctx, user_id, loc, update_task)
+}
+
+// ValidateUpdateTask checks the changed fields of a task, reading a due date
+// in the legacy layout in loc.
+func ValidateUpdateTask(DB *sql.DB, ctx context.Context, user_id int, loc *time.Location, update_task models.UpdateTask) error {
+	// Title
+
 	if update_task.Title != nil {
 		if *update_task.Title == "" {
-			return update_task, errors.New("update requirements not met, can't be empty")
+			return errors.New("update requirements not met, can't be empty")
 		}
 
 		if !task_utils.ValidString(*update_task.Title) {
-			return update_task, errors.New("update requirements not met, not valid string")
+			return errors.New("update requirements not met, not valid string")
 		}
 
 		if postgres.TaskExists(DB, ctx, user_id, *update_task.Title) {
-			return update_task, errors.New("unique task violation: task already exists")
+			return errors.New("unique task violation: task already exists")
 		}
 	}
 
 	// Due_date
 
 	if update_task.Due_date != nil {
-		date, err := time.Parse(layout, *update_task.Due_date)
+		date, err := task_utils.ParseDue(*update_task.Due_date, loc)
 
 		if err != nil {
-			return update_task, errors.New("due time requirements not met, should be YYYY-MM-DD  HH:MM:SS")
+			return errors.New("due time requirements not met, should be RFC 3339 or YYYY-MM-DD HH:MM:SS")
 		}
 
 		if time.Since(date) >= 0 {
-			return update_task, errors.New("due time requirements not met, should be > Current time")
+			return errors.New("due time requirements not met, should be > Current time")
 		}
 	}
 
@@ -193,11 +361,11 @@ func GetValidateUpdateParams(DB *sql.DB, ctx context.Context, user_id int, r *ht
 
 	if update_task.Priority != nil {
 		if *update_task.Priority == "" {
-			return update_task, errors.New("update requirements not met, can't be empty")
+			return errors.New("update requirements not met, can't be empty")
 		}
 
-		if *update_task.Priority != "low" && *update_task.Priority != "medium" && *update_task.Priority != "high" {
-			return update_task, errors.New("update requirements not met, priority must be in ('low', 'medium', 'high')")
+		if _, ok := task_utils.PriorityLevels[*update_task.Priority]; !ok {
+			return errors.New("update requirements not met, priority must be in " + task_utils.PriorityList())
 		}
 	}
 
@@ -205,13 +373,21 @@ func GetValidateUpdateParams(DB *sql.DB, ctx context.Context, user_id int, r *ht
 
 	if update_task.Category != nil {
 		if *update_task.Category == "" {
-			return update_task, errors.New("update requirements not met, can't be empty")
+			return errors.New("update requirements not met, can't be empty")
 		}
 
 		if !task_utils.ValidString(*update_task.Category) {
-			return update_task, errors.New("update requirements not met, not valid string")
+			return errors.New("update requirements not met, not valid string")
 		}
 	}
 
-	return update_task, nil
+	// Status
+
+	if update_task.Status != nil {
+		if *update_task.Status == "" {
+			return errors.New("update requirements not met, can't be empty")
+		}
+	}
+
+	return nil
 }
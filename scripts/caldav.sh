@@ -0,0 +1,69 @@
+#!/usr/bin/env sh
+# Exercises the CalDAV endpoints against a local server.
+#
+#   DAV_USER=me@example.com DAV_PASSWORD=<app password> ./scripts/caldav.sh
+#
+# An app password is created with POST /app-passwords while logged in.
+
+set -eu
+
+BASE=${BASE:-http://localhost:${ADDR:-8080}}
+COLLECTION="$BASE/dav/calendars/me/tasks/"
+NAME="smoke-$(date +%s).ics"
+DUE=$(date -u -d '+2 days' +%Y%m%dT%H%M%SZ 2>/dev/null || date -u -v+2d +%Y%m%dT%H%M%SZ)
+
+dav() {
+	curl -sS -u "$DAV_USER:$DAV_PASSWORD" "$@"
+	echo
+}
+
+echo "== discovery"
+dav -X PROPFIND -H "Depth: 0" "$BASE/dav/" \
+	--data '<d:propfind xmlns:d="DAV:"><d:prop><d:current-user-principal/></d:prop></d:propfind>'
+dav -X PROPFIND -H "Depth: 1" "$BASE/dav/calendars/me/" \
+	--data '<d:propfind xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/"><d:prop><d:resourcetype/><cs:getctag/></d:prop></d:propfind>'
+
+echo "== create $NAME"
+dav -X PUT -H "If-None-Match: *" -H "Content-Type: text/calendar" -D - -o /dev/null "$COLLECTION$NAME" \
+	--data-binary "BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//smoke//EN
+BEGIN:VTODO
+UID:$NAME
+SUMMARY:CalDAV smoke $NAME
+DUE:$DUE
+PRIORITY:1
+CATEGORIES:smoke
+END:VTODO
+END:VCALENDAR"
+
+echo "== calendar-query"
+dav -X REPORT -H "Depth: 1" "$COLLECTION" \
+	--data '<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav"><d:prop><d:getetag/></d:prop><c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="VTODO"/></c:comp-filter></c:filter></c:calendar-query>'
+
+echo "== multiget"
+dav -X REPORT -H "Depth: 1" "$COLLECTION" \
+	--data "<c:calendar-multiget xmlns:d=\"DAV:\" xmlns:c=\"urn:ietf:params:xml:ns:caldav\"><d:prop><d:getetag/><c:calendar-data/></d:prop><d:href>/dav/calendars/me/tasks/$NAME</d:href></c:calendar-multiget>"
+
+ETAG=$(curl -sS -u "$DAV_USER:$DAV_PASSWORD" -I "$COLLECTION$NAME" | tr -d '\r' | sed -n 's/^[Ee][Tt][Aa][Gg]: //p')
+
+echo "== complete with If-Match $ETAG"
+dav -X PUT -H "If-Match: $ETAG" -H "Content-Type: text/calendar" -D - -o /dev/null "$COLLECTION$NAME" \
+	--data-binary "BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//smoke//EN
+BEGIN:VTODO
+UID:$NAME
+SUMMARY:CalDAV smoke $NAME
+DUE:$DUE
+PRIORITY:1
+CATEGORIES:smoke
+STATUS:COMPLETED
+END:VTODO
+END:VCALENDAR"
+
+echo "== stale If-Match is rejected (expect 412)"
+dav -X DELETE -H "If-Match: $ETAG" -o /dev/null -w "%{http_code}" "$COLLECTION$NAME"
+
+echo "== delete"
+dav -X DELETE -o /dev/null -w "%{http_code}" "$COLLECTION$NAME"